@@ -4,7 +4,6 @@ import (
 	"context"
 	"github.com/blackbeans/turbo"
 	"log"
-	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"time"
@@ -46,29 +45,18 @@ func main() {
 		}
 	}()
 
-	//创建物理连接
-	conn, _ := func(hostport string) (*net.TCPConn, error) {
-		//连接
-		remoteAddr, err_r := net.ResolveTCPAddr("tcp4", hostport)
-		if nil != err_r {
-			log.Printf("KiteClientManager|RECONNECT|RESOLVE ADDR |FAIL|remote:%s\n", err_r)
-			return nil, err_r
-		}
-		conn, err := net.DialTCP("tcp4", nil, remoteAddr)
-		if nil != err {
-			log.Printf("KiteClientManager|RECONNECT|%s|FAIL|%s", hostport, err)
-			return nil, err
-		}
-
-		return conn, nil
-	}("localhost:28888")
-
-	client := turbo.NewTClient(context.Background(), conn,
-		func() turbo.ICodec {
+	//创建连接并启动
+	client, err := turbo.Dial(context.Background(), "localhost:28888", turbo.DialOptions{
+		Timeout: 5 * time.Second,
+		Codec: func() turbo.ICodec {
 			return turbo.LengthBytesCodec{
 				MaxFrameLength: turbo.MAX_PACKET_BYTES}
-		}, onMessage, rcc)
-	client.Start()
+		},
+		Handler: onMessage,
+		Config:  rcc})
+	if nil != err {
+		log.Fatalf("Dial|FAIL|%v", err)
+	}
 
 	auth := &turbo.GroupAuth{}
 	auth.GroupId = "a"
@@ -4,7 +4,9 @@ go 1.16
 
 require (
 	github.com/blackbeans/logx v0.0.0-20230518151533-7059fbb3d603
+	github.com/klauspost/compress v1.15.9
 	github.com/mattn/go-isatty v0.0.18 // indirect
 	github.com/sirupsen/logrus v1.9.2 // indirect
 	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
+	google.golang.org/protobuf v1.31.0
 )
@@ -75,7 +75,6 @@ func (self *WorkUnit) AttachValue(val interface{}) {
 	})
 }
 
-//
 func (self *WorkUnit) Error(err error) {
 	self.once.Do(func() {
 		self.Err = err
@@ -109,54 +108,92 @@ var ERR_QUEUE_TIMEOUT = errors.New("Queue TIMEOUT!")
 //取消
 var ERR_QUEUE_CONTEXT_DONE = errors.New("Context is Done!")
 
+//分类为取消的错误,errors.Is(err,ERR_QUEUE_CONTEXT_DONE)成立
+func errQueueContextDone() error {
+	return newTurboError(ErrKindCancelled, ERR_QUEUE_CONTEXT_DONE, "", 0, 0)
+}
+
+//等待空闲goroutine超时,分类为背压,errors.Is(err,ERR_QUEUE_TIMEOUT)成立
+func errQueueTimeout() error {
+	return newTurboError(ErrKindBackpressure, ERR_QUEUE_TIMEOUT, "", 0, 0)
+}
+
+//没有空闲goroutine,分类为背压,errors.Is(err,ERR_OVER_FLOW)成立
+func errOverFlow() error {
+	return newTurboError(ErrKindBackpressure, ERR_OVER_FLOW, "", 0, 0)
+}
+
 //如果想使用超时获取的
 func (self *GPool) Queue(ctx context.Context, work WorkFunc) (*WorkUnit, error) {
 	wu := &WorkUnit{ch: make(chan *interface{}, 1), work: work, ctx: ctx}
 	return wu, self.queue(wu)
 }
 
+//不等待空闲goroutine,pool满了直接返回ERR_OVER_FLOW
+func (self *GPool) TryQueue(ctx context.Context, work WorkFunc) (*WorkUnit, error) {
+	wu := &WorkUnit{ch: make(chan *interface{}, 1), work: work, ctx: ctx}
+	select {
+	case self.limiter <- nil:
+		self.run(wu)
+		return wu, nil
+	default:
+		wu.Error(errOverFlow())
+		return wu, wu.Err
+	}
+}
+
 //执行异步任务
 func (self *GPool) queue(wu *WorkUnit) error {
 	select {
 	case <-wu.ctx.Done():
-		wu.Error(ERR_QUEUE_CONTEXT_DONE)
+		//等待空闲goroutine的时候超时
+		if errors.Is(wu.ctx.Err(), context.DeadlineExceeded) {
+			wu.Error(errQueueTimeout())
+		} else {
+			wu.Error(errQueueContextDone())
+		}
 		return wu.Err
 	case self.limiter <- nil:
-		go func() {
-			defer func() {
-				<-self.limiter
-				if err := recover(); nil != err {
-					log.Errorf("GPool|Queue|Panic|%v|%s", err, string(debug.Stack()))
-					wu.Error(fmt.Errorf("%v", err))
-				}
-			}()
-
-			select {
-			case <-self.ctx.Done():
-				wu.Error(ERR_QUEUE_CONTEXT_DONE)
-				return
-			case <-wu.ctx.Done():
-				//当前工作单元上下文取消那么久取消
-				wu.Error(ERR_QUEUE_CONTEXT_DONE)
-				return
-			default:
-				//没有结束执行下面的
-			}
-			//执行异步方法
-			val, err := wu.work(wu.ctx)
-			if nil != err {
-				wu.Error(err)
-			} else {
-				wu.AttachValue(val)
-			}
-		}()
+		self.run(wu)
 	case <-self.ctx.Done():
-		wu.Error(ERR_QUEUE_CONTEXT_DONE)
+		wu.Error(errQueueContextDone())
 		return wu.Err
 	}
 	return nil
 }
 
+//已经拿到limiter,启动goroutine执行
+func (self *GPool) run(wu *WorkUnit) {
+	go func() {
+		defer func() {
+			<-self.limiter
+			if err := recover(); nil != err {
+				log.Errorf("GPool|Queue|Panic|%v|%s", err, string(debug.Stack()))
+				wu.Error(fmt.Errorf("%v", err))
+			}
+		}()
+
+		select {
+		case <-self.ctx.Done():
+			wu.Error(errQueueContextDone())
+			return
+		case <-wu.ctx.Done():
+			//当前工作单元上下文取消那么久取消
+			wu.Error(errQueueContextDone())
+			return
+		default:
+			//没有结束执行下面的
+		}
+		//执行异步方法
+		val, err := wu.work(wu.ctx)
+		if nil != err {
+			wu.Error(err)
+		} else {
+			wu.AttachValue(val)
+		}
+	}()
+}
+
 //返回当前正在运行goroutine、gopool的大小
 func (self *GPool) Monitor() (int, int) {
 	return len(self.limiter), cap(self.limiter)
@@ -200,7 +237,7 @@ func (self *Batch) Wait(ctx context.Context) ([]*WorkUnit, error) {
 		//提交异步处理
 		err := self.gopool.queue(wu)
 		if nil != err {
-			if err == ERR_QUEUE_TIMEOUT {
+			if errors.Is(err, ERR_QUEUE_TIMEOUT) {
 
 			}
 		}
@@ -214,7 +251,7 @@ func (self *Batch) Wait(ctx context.Context) ([]*WorkUnit, error) {
 			for j := i; j < len(wus); j++ {
 				//直接给结果
 				if nil == wus[j].Err {
-					wus[j].Error(ERR_QUEUE_CONTEXT_DONE)
+					wus[j].Error(errQueueContextDone())
 				}
 			}
 			return wus, nil
@@ -223,7 +260,7 @@ func (self *Batch) Wait(ctx context.Context) ([]*WorkUnit, error) {
 			for j := i; j < len(wus); j++ {
 				//直接给结果
 				if nil == wus[j].Err {
-					wus[j].Error(ERR_QUEUE_CONTEXT_DONE)
+					wus[j].Error(errQueueContextDone())
 				}
 			}
 			return wus, nil
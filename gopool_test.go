@@ -2,6 +2,7 @@ package turbo
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"testing"
@@ -184,7 +185,7 @@ func TestGPool_Cancel(t *testing.T) {
 	for _, wu := range wus {
 		resp, err := wu.Get()
 		fmt.Printf("%v|%v\n", err, resp)
-		if err != nil && err != ERR_QUEUE_CONTEXT_DONE {
+		if err != nil && !errors.Is(err, ERR_QUEUE_CONTEXT_DONE) {
 			fmt.Printf("Should Not Timeout %v|%v\n", err, resp)
 			t.FailNow()
 		}
@@ -207,3 +208,31 @@ func TestGPool_Cancel(t *testing.T) {
 
 	cancel()
 }
+
+func TestGPool_Backpressure(t *testing.T) {
+	gpool := NewLimitPool(context.Background(), 1)
+	defer gpool.Close()
+	block := make(chan struct{})
+	defer close(block)
+	if _, err := gpool.Queue(context.Background(), func(ctx context.Context) (interface{}, error) {
+		<-block
+		return nil, nil
+	}); nil != err {
+		t.Fatal(err)
+	}
+
+	work := func(ctx context.Context) (interface{}, error) {
+		return "a", nil
+	}
+	_, err := gpool.TryQueue(context.Background(), work)
+	if !errors.Is(err, ERR_OVER_FLOW) || ErrorKindOf(err) != ErrKindBackpressure {
+		t.Fatalf("expect over flow %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = gpool.Queue(ctx, work)
+	if !errors.Is(err, ERR_QUEUE_TIMEOUT) || ErrorKindOf(err) != ErrKindBackpressure {
+		t.Fatalf("expect queue timeout %v", err)
+	}
+}
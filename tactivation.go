@@ -0,0 +1,55 @@
+package turbo
+
+import (
+	"net"
+	"os"
+	"strconv"
+)
+
+//systemd socket activation传递的第一个fd
+const LISTEN_FDS_START = 3
+
+//获取systemd socket activation传递的listener
+//LISTEN_PID不是当前进程或者没有LISTEN_FDS返回空
+//获取之后清理环境变量,避免子进程重复使用
+//	listeners, err := turbo.ActivationListeners()
+//	for _, l := range listeners {
+//		server.Serve(l)
+//	}
+func ActivationListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if nil != err || pid != os.Getpid() {
+		return nil, nil
+	}
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	return activationListeners(LISTEN_FDS_START)
+}
+
+//从start开始的LISTEN_FDS个fd创建listener
+func activationListeners(start int) ([]net.Listener, error) {
+	num, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if nil != err || num <= 0 {
+		return nil, nil
+	}
+
+	listeners := make([]net.Listener, 0, num)
+	for fd := start; fd < start+num; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		//FileListener会dup一个新的fd,关闭原来的fd避免泄露给子进程
+		l, err := net.FileListener(f)
+		f.Close()
+		if nil != err {
+			log.Errorf("ActivationListeners|FileListener|FAIL|%v|fd:%d", err, fd)
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,50 @@
+package turbo
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestActivationListeners(t *testing.T) {
+	//不是当前进程的fd不使用
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if listeners, err := ActivationListeners(); nil != err || len(listeners) > 0 {
+		t.Fatalf("listeners of other process should be ignored %v|%v", listeners, err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if nil != err {
+		t.Fatal(err)
+	}
+	listeners, err := activationListeners(int(f.Fd()))
+	//fd已经被activationListeners关闭
+	f.Close()
+	if nil != err || len(listeners) != 1 {
+		t.Fatalf("unexpected listeners %v|%v", listeners, err)
+	}
+	if listeners[0].Addr().String() != l.Addr().String() {
+		t.Fatalf("inherited listener %s should be bound to %s", listeners[0].Addr(), l.Addr())
+	}
+
+	server := NewTServer("", NewTConfig("activation", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100), delayEcho(0))
+	if err := server.Serve(listeners[0]); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+	client, err := Dial(context.Background(), server.Addr().String(), dialOptions("activation-client"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+	echo(t, client)
+}
@@ -0,0 +1,52 @@
+package turbo
+
+import (
+	"sync"
+)
+
+//读取body的缓冲池,按照2的幂分级
+//最小64B,最大到MAX_PACKET_BYTES,超过的直接分配不回收
+const (
+	minBufferShift = 6
+	maxBufferShift = 21
+)
+
+var bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool
+
+//获取至少size字节的缓冲,使用(*buff)[:size]
+//保存指针归还时不会再分配
+func getBuffer(size int) *[]byte {
+	idx := bufferClass(size)
+	if idx < 0 {
+		b := make([]byte, size)
+		return &b
+	}
+	if b, ok := bufferPools[idx].Get().(*[]byte); ok {
+		return b
+	}
+	b := make([]byte, 1<<uint(idx+minBufferShift))
+	return &b
+}
+
+//归还缓冲,不是从池中获取的直接丢弃
+func putBuffer(buff *[]byte) {
+	c := cap(*buff)
+	idx := bufferClass(c)
+	if idx < 0 || c != 1<<uint(idx+minBufferShift) {
+		return
+	}
+	*buff = (*buff)[:c]
+	bufferPools[idx].Put(buff)
+}
+
+//size所在的分级,超过最大分级返回-1
+func bufferClass(size int) int {
+	if size > 1<<maxBufferShift {
+		return -1
+	}
+	idx := 0
+	for size > 1<<uint(idx+minBufferShift) {
+		idx++
+	}
+	return idx
+}
@@ -0,0 +1,121 @@
+package turbo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBufferPool(t *testing.T) {
+	b := getBuffer(100)
+	if cap(*b) != 128 {
+		t.Fatalf("unexpected cap %d", cap(*b))
+	}
+	putBuffer(b)
+	if cap(*getBuffer(1)) != 64 || cap(*getBuffer(MAX_PACKET_BYTES)) != MAX_PACKET_BYTES {
+		t.Fatal("unexpected buffer class")
+	}
+
+	//超过最大分级的不放回缓冲池
+	b = getBuffer(MAX_PACKET_BYTES + 1)
+	if cap(*b) != MAX_PACKET_BYTES+1 {
+		t.Fatalf("unexpected cap %d", cap(*b))
+	}
+	putBuffer(b)
+
+	p := Packet{}
+	p.buff = getBuffer(10)
+	p.Data = (*p.buff)[:10]
+	p.Release()
+	if nil != p.Data || nil != p.buff {
+		t.Fatal("released packet should not hold data")
+	}
+	//重复Release没有影响
+	p.Release()
+}
+
+func TestUnmarshalHeader(t *testing.T) {
+	header := PacketHeader{Opaque: 1, CmdType: 2, Version: -3, Extension: 1 << 60}
+	buff := MarshalHeader(header, 100)
+	if buff.Len() != PACKET_HEAD_LEN {
+		t.Fatalf("unexpected header len %d", buff.Len())
+	}
+	//和反射的结果一致
+	var expect PacketHeader
+	header.BodyLen = 100
+	binary.Read(bytes.NewReader(buff.Bytes()), binary.BigEndian, &expect)
+	h, err := UnmarshalHeader(bytes.NewReader(buff.Bytes()))
+	if nil != err || h != header || h != expect {
+		t.Fatalf("unexpected header %+v|%+v|%v", h, expect, err)
+	}
+}
+
+func BenchmarkUnmarshalHeaderReflect(b *testing.B) {
+	raw := MarshalHeader(PacketHeader{Opaque: 1, CmdType: 2}, 100).Bytes()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var h PacketHeader
+		binary.Read(bytes.NewReader(raw), binary.BigEndian, &h)
+	}
+}
+
+func BenchmarkUnmarshalHeader(b *testing.B) {
+	raw := MarshalHeader(PacketHeader{Opaque: 1, CmdType: 2}, 100).Bytes()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		DefaultFrameFormat.UnmarshalHeader(raw)
+	}
+}
+
+//读取b.N个1KB的包
+func benchmarkSessionRead(b *testing.B, release bool) {
+	config := NewTConfig("bench", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100)
+	frame := DefaultFrameFormat
+	p := NewPacket(1, make([]byte, 1024))
+	raw, _ := frame.AppendHeader(nil, p.Header, int32(len(p.Data)))
+	raw = append(raw, p.Data...)
+	//批量写入减少管道的开销
+	batch := bytes.Repeat(raw, 64)
+
+	r, w := net.Pipe()
+	defer w.Close()
+	done := make(chan bool, 1)
+	count := 0
+	s := NewSession(r, config, func(msg Packet, err error) {
+		if release {
+			msg.Release()
+		}
+		count++
+		if count == b.N {
+			done <- true
+		}
+	})
+	defer s.Close()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	b.ResetTimer()
+	s.Open()
+	go func() {
+		for i := 0; i < b.N; i += 64 {
+			n := b.N - i
+			if n > 64 {
+				n = 64
+			}
+			if _, err := w.Write(batch[:n*len(raw)]); nil != err {
+				return
+			}
+		}
+	}()
+	<-done
+}
+
+func BenchmarkSessionRead(b *testing.B) {
+	benchmarkSessionRead(b, false)
+}
+
+func BenchmarkSessionReadRelease(b *testing.B) {
+	benchmarkSessionRead(b, true)
+}
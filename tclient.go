@@ -2,30 +2,46 @@ package turbo
 
 import (
 	"context"
-	"errors"
-	"fmt"
+	"crypto/tls"
 	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 //网络层的client
 type TClient struct {
-	conn       *net.TCPConn
-	localAddr  string
-	remoteAddr string
-	heartbeat  int64
-	wchan      chan *Packet //response的channel
-	s          *TSession
-	dis        THandler //包处理函数
-	codec      func() ICodec
-	config     *TConfig
-	authSecond int64 //授权成功时间
-	ctx        context.Context
-	closeFunc  context.CancelFunc
+	slock            sync.RWMutex //重连时替换conn、s和localAddr
+	conn             net.Conn
+	localAddr        string
+	remoteAddr       string
+	heartbeat        int64        //最后一次pong的版本,atomic访问
+	wchan            chan *Packet //response的channel
+	s                *TSession
+	writerDone       chan bool //当前写协程退出时关闭
+	dis              THandler  //包处理函数
+	codec            func() ICodec
+	config           *TConfig
+	authSecond       int64 //授权成功时间
+	ctx              context.Context
+	closeFunc        context.CancelFunc
+	schan            chan *Packet   //大包分片的channel,优先级低于wchan
+	streams          *streams       //正在接收的大包
+	pending          int32          //已经入队但还没有写出的包数
+	inflight         int32          //已经分发但处理器还没有返回的包数
+	goaway           int32          //对端发送了GOAWAY
+	heartbeatTid     uint32         //心跳检查的timer
+	streamTid        uint32         //未完成大包的空闲检查timer
+	missedHeartbeats int32          //连续没有响应的心跳次数
+	expiring         int32          //超过最长存活时间,已经发送GOAWAY等待关闭
+	onClose          func(*TClient) //连接关闭的回调
+	dialAddr         string         //Dial的地址,重连使用
+	dialOptions      *DialOptions   //Dial的参数,重连使用
 }
 
 func NewTClient(parent context.Context,
-	conn *net.TCPConn, codec func() ICodec, dis THandler,
+	conn net.Conn, codec func() ICodec, dis THandler,
 	config *TConfig) *TClient {
 
 	//初始化下cancel
@@ -36,11 +52,16 @@ func NewTClient(parent context.Context,
 		conn:      conn,
 		dis:       dis,
 		wchan:     make(chan *Packet, config.WriteChannelSize),
+		schan:     make(chan *Packet, STREAM_CHANNEL_SIZE),
+		streams:   newStreams(),
 		config:    config,
 		codec:     codec,
 		ctx:       ctx,
 		closeFunc: closeFunc}
-
+	if nil != conn {
+		//Start之前就需要用remoteAddr注册连接
+		tclient.localAddr, tclient.remoteAddr = connAddrs(conn)
+	}
 	return tclient
 }
 
@@ -49,77 +70,233 @@ func (self *TClient) RemoteAddr() string {
 }
 
 func (self *TClient) LocalAddr() string {
+	self.slock.RLock()
+	defer self.slock.RUnlock()
 	return self.localAddr
 }
 
+//当前连接的session,重连之后为新的session
+func (self *TClient) session() *TSession {
+	self.slock.RLock()
+	defer self.slock.RUnlock()
+	return self.s
+}
+
+//tls连接的状态，非tls连接返回false
+//可以用来获取对端证书做鉴权
+func (self *TClient) TLSConnectionState() (tls.ConnectionState, bool) {
+	self.slock.RLock()
+	conn := self.conn
+	self.slock.RUnlock()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		return tlsConn.ConnectionState(), true
+	}
+	return tls.ConnectionState{}, false
+}
+
 func (self *TClient) Idle() bool {
-	return self.s.Idle()
+	return self.session().Idle()
+}
+
+//连接建立的时间
+func (self *TClient) ConnectedTime() time.Time {
+	return self.session().CreateTime()
+}
+
+//最后一次读取到包的时间
+func (self *TClient) LastReadTime() time.Time {
+	return self.session().LastReadTime()
+}
+
+//最后一次写出的时间
+func (self *TClient) LastWriteTime() time.Time {
+	return self.session().LastWriteTime()
+}
+
+//对端是否已经通知即将关闭
+//即将关闭的连接不应该再发送新的请求
+func (self *TClient) IsGoaway() bool {
+	return atomic.LoadInt32(&self.goaway) == 1
+}
+
+//处理turbo内部的控制命令
+func (self *TClient) onControl(msg Packet) {
+	switch msg.Header.CmdType {
+	case CMD_PING, CMD_PONG:
+		self.onHeartbeat(msg)
+	case CMD_GOAWAY:
+		atomic.StoreInt32(&self.goaway, 1)
+		log.Infof("TClient|onControl|GOAWAY|%s", self.remoteAddr)
+	case CMD_ERROR:
+		code, message, err := ParseErrorPacket(&msg)
+		if nil != err {
+			log.Errorf("TClient|onControl|ERROR|FAIL|%s|%v", self.remoteAddr, err)
+			return
+		}
+		log.Warnf("TClient|onControl|ERROR|%s|opaque:%d|code:%d|%s", self.remoteAddr, msg.Header.Opaque, code, message)
+		//等待响应的请求直接返回对端的错误
+		self.config.RequestHolder.DetachError(msg.Header.Opaque, newTurboError(ErrKindRemote,
+			&RemoteError{Code: code, Message: message}, self.remoteAddr, msg.Header.Opaque, 0))
+	default:
+		log.Warnf("TClient|onControl|UNKNOWN|%s|%d", self.remoteAddr, msg.Header.CmdType)
+	}
+}
+
+//通知对端当前连接即将关闭
+func (self *TClient) goAway() error {
+	return self.Write(*NewPacket(CMD_GOAWAY, nil))
 }
 
 //当接收到该链接的包
 func (self *TClient) onMessage(msg Packet, err error) {
 
+	if nil == err && IsControlCmd(msg.Header.CmdType) {
+		self.onControl(msg)
+		msg.Release()
+		return
+	}
+
 	//如果有错误，那么需要回给客户端错误包
 	if nil != err {
 		log.Errorf("TSession|onMessage|FAIL|%s|%v", self.remoteAddr, err)
+		self.fireError(err)
 		ctx := &TContext{
 			Message: &msg,
 			Client:  self,
 			Err:     err,
 		}
+		self.replyError(msg.Header, err)
 		err = self.dis(ctx)
 		if nil != err {
 			log.Errorf("TSession|onMessage|dis|FAIL|%s|%v", self.remoteAddr, err)
 		}
+	} else if msg.Header.Extension&EXT_CHUNK != 0 {
+		//大包的分片
+		self.onChunk(msg)
 	} else {
-		p := &msg
-		self.config.dispool.Queue(self.ctx, func(cctx context.Context) (interface{}, error) {
-			//解析包
-			message, err := self.codec().UnmarshalPayload(p)
+		self.dispatch(&msg)
+	}
+}
+
+//对端的包超过限制或者大包接收不过来,回复错误响应而不是直接断开
+func (self *TClient) replyError(header PacketHeader, err error) {
+	//不回复控制命令和响应包,避免和对端自己的请求混淆
+	kind := ErrorKindOf(err)
+	if (kind != ErrKindTooLarge && kind != ErrKindBackpressure) || IsControlCmd(header.CmdType) || header.IsResponse() ||
+		!carryFlags(frameFormat(self.config)) {
+		return
+	}
+	if werr := self.Write(*NewErrorPacket(header.Opaque, errorCodeOf(err), err.Error())); nil != werr {
+		log.Errorf("TClient|replyError|FAIL|%s|%v", self.remoteAddr, werr)
+	}
+}
+
+//在分发的pool中解析并处理包
+func (self *TClient) dispatch(p *Packet) {
+	atomic.AddInt32(&self.inflight, 1)
+	_, qerr := self.config.dispool.Queue(self.ctx, func(cctx context.Context) (interface{}, error) {
+		defer atomic.AddInt32(&self.inflight, -1)
+		//解析包
+		message, err := self.codec().UnmarshalPayload(p)
+		if p.Header.IsResponse() {
+			//响应包直接交给等待的Future,不再经过处理器
 			if nil != err {
-				// 构造一个error的响应包
-				log.Errorf("TSession|UnmarshalPayload|%s|FAIL|%v|bodyLen:%d",
-					self.remoteAddr, err, msg.Header.BodyLen)
-				ctx := &TContext{
-					Message: p,
-					Client:  self,
-					Err:     err,
-				}
-				err = self.dis(ctx)
-				return nil, nil
+				log.Errorf("TSession|UnmarshalPayload|%s|FAIL|%v|response|opaque:%d",
+					self.remoteAddr, err, p.Header.Opaque)
+				self.config.RequestHolder.DetachError(p.Header.Opaque,
+					newTurboError(ErrKindCodec, err, self.remoteAddr, p.Header.Opaque, p.Header.CmdType))
+			} else {
+				self.config.RequestHolder.Detach(p.Header.Opaque, message)
 			}
-
-			//强制设置payload
-			p.PayLoad = message
-			//创建上下文
+			return nil, nil
+		}
+		if nil != err {
+			// 构造一个error的响应包
+			log.Errorf("TSession|UnmarshalPayload|%s|FAIL|%v|bodyLen:%d",
+				self.remoteAddr, err, p.Header.BodyLen)
 			ctx := &TContext{
 				Message: p,
 				Client:  self,
+				Err:     err,
 			}
-			//处理一下包
 			err = self.dis(ctx)
-			if nil != err {
-				log.Errorf("TSession|onMessage|dis|FAIL|%s|%v", self.remoteAddr, err)
-			}
-			return nil, err
-		})
+			return nil, nil
+		}
+
+		//强制设置payload
+		p.PayLoad = message
+		//创建上下文
+		ctx := &TContext{
+			Message: p,
+			Client:  self,
+		}
+		//处理一下包
+		err = self.dis(ctx)
+		if nil != err {
+			log.Errorf("TSession|onMessage|dis|FAIL|%s|%v", self.remoteAddr, err)
+		}
+		return nil, err
+	})
+	if nil != qerr {
+		//没有进入pool,不会执行
+		atomic.AddInt32(&self.inflight, -1)
 	}
 }
 
 //启动当前的client
 func (self *TClient) Start() {
+	self.start(self.conn)
+}
 
+//在conn上启动新的session,重连时替换掉已经关闭的session,旧的session没有关闭会一直等待
+func (self *TClient) start(conn net.Conn) {
+	//等待上一个session的写协程退出,避免写入新的session
+	if nil != self.writerDone {
+		<-self.writerDone
+	}
+	//重新初始化,新的连接上对端还没有发送GOAWAY
+	atomic.StoreInt32(&self.goaway, 0)
+	//remoteAddr是注册连接的key,已经确定的不再改变
+	local, remote := connAddrs(conn)
+	remoteAddr := self.remoteAddr
+	if len(remoteAddr) <= 0 {
+		remoteAddr = remote
+	}
 	//启动session
-	self.s = NewSession(self.conn, self.config, self.onMessage)
-	//重新初始化
-	laddr := self.conn.LocalAddr().(*net.TCPAddr)
-	raddr := self.conn.RemoteAddr().(*net.TCPAddr)
-	self.localAddr = fmt.Sprintf("%s:%d", laddr.IP, laddr.Port)
-	self.remoteAddr = fmt.Sprintf("%s:%d", raddr.IP, raddr.Port)
+	s := newSession(conn, remoteAddr, self.config, self.onMessage)
+	//收发上限同时受codec的限制
+	codec := self.codec()
+	s.maxInbound = frameLimit(self.config.MaxInboundFrame, codec)
+	s.maxOutbound = frameLimit(self.config.MaxOutboundFrame, codec)
+	s.onError = self.fireError
+	s.onClose = func(reason error) {
+		self.stopHeartbeat()
+		self.stopStreamSweep()
+		//没有接收完的大包直接中断
+		self.streams.abortAll(ERR_CONNECTION_BROKEN)
+		if nil != self.onClose {
+			self.onClose(self)
+		}
+		if nil != self.config.OnClose {
+			self.config.OnClose(self, reason)
+		}
+	}
+	self.slock.Lock()
+	self.conn = conn
+	self.localAddr = local
+	self.remoteAddr = remoteAddr
+	self.s = s
+	self.slock.Unlock()
+
 	//启动读取
-	self.s.Open()
+	s.Open()
 	//启动异步写出
-	self.asyncWrite()
+	self.asyncWrite(s)
+	//启动心跳检查
+	self.startHeartbeat()
+	if nil != self.config.OnConnect {
+		self.config.OnConnect(self)
+	}
 
 	log.Infof("TClient|Start|SUCC|local:%s|remote:%s", self.LocalAddr(), self.RemoteAddr())
 }
@@ -139,9 +316,13 @@ func (self *TClient) Ping(heartbeat *Packet, timeout time.Duration) error {
 	return nil
 }
 
+//onHeartbeat和Ping的调用方并发更新,只保留最新的版本
 func (self *TClient) updateHeartBeat(version int64) {
-	if version > self.heartbeat {
-		self.heartbeat = version
+	for {
+		old := atomic.LoadInt64(&self.heartbeat)
+		if version <= old || atomic.CompareAndSwapInt64(&self.heartbeat, old, version) {
+			return
+		}
 	}
 }
 
@@ -153,9 +334,12 @@ func (self *TClient) fillOpaque(p *Packet) uint32 {
 	tid := p.Header.Opaque
 	//只有在默认值没有赋值的时候才去赋值
 	if tid <= 0 {
-		id := self.config.RequestHolder.CurrentOpaque()
-		p.Header.Opaque = id
-		tid = id
+		//按照帧格式的opaque宽度回绕,0为没有赋值需要跳过
+		mask := opaqueMask(frameFormat(self.config))
+		for tid <= 0 {
+			tid = self.config.RequestHolder.CurrentOpaque() & mask
+		}
+		p.Header.Opaque = tid
 	}
 
 	return tid
@@ -177,10 +361,86 @@ func (self *TClient) Attach(opaque uint32, obj interface{}) {
 func (self *TClient) WriteAndGet(p Packet,
 	timeout time.Duration) (interface{}, error) {
 
-	pp := &p
+	future, tchan := self.writeFuture(&p, timeout)
+	resp, err := future.Get(tchan)
+	return resp, err
+}
+
+//写数据并且得到响应,支持请求级别的取消
+//超时时间取ctx的deadline和TConfig.RequestTimeout中较早的
+//ctx结束返回ctx.Err(),配置的超时先到则返回ERR_TIMEOUT
+func (self *TClient) WriteAndGetContext(ctx context.Context, p *Packet) (interface{}, error) {
+	if err := ctx.Err(); nil != err {
+		return nil, err
+	}
+
+	timeout, ctxBound := self.requestTimeout(ctx)
+	future, tchan := self.writeFuture(p, timeout)
+	return self.getContext(ctx, future, tchan, ctxBound)
+}
+
+//请求的超时时间,取ctx的deadline和TConfig.RequestTimeout中较早的
+//返回的bool表示是否由ctx的deadline决定超时
+func (self *TClient) requestTimeout(ctx context.Context) (time.Duration, bool) {
+	timeout := self.config.RequestTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		remain := time.Until(deadline)
+		if timeout <= 0 || remain < timeout {
+			return remain, true
+		}
+	}
+	return timeout, false
+}
+
+//等待future的响应
+func (self *TClient) getContext(ctx context.Context, future *Future,
+	tchan chan time.Time, ctxBound bool) (interface{}, error) {
+	if ctxBound {
+		//由ctx的deadline决定超时
+		tchan = nil
+	}
+	resp, err := future.GetContext(ctx, tchan)
+	if nil != ctx.Err() && err == ctx.Err() {
+		//调用方已经放弃了,移除掉等待的future
+		self.config.RequestHolder.Remove(future.opaque)
+	}
+	return resp, err
+}
+
+//分组写入
+func (self *TClient) GroupWriteAndGet(timeout time.Duration, packets ...Packet) ([]*Future, error) {
+
+	futures := make([]*Future, 0, len(packets))
+	for i := range packets {
+		future, _ := self.writeFuture(&(packets[i]), timeout)
+		futures = append(futures, future)
+	}
+	return futures, nil
+}
+
+//异步写入,响应到达或者超时之后在分发的GPool中回调callback
+//不需要为每个等待的请求创建goroutine
+func (self *TClient) WriteAsync(p *Packet, timeout time.Duration,
+	callback func(resp interface{}, err error)) {
+	opaque := self.fillOpaque(p)
+	future := NewAsyncFuture(opaque, timeout, self.remoteAddr, self.ctx, self.config.dispool, callback)
+	future.cmdType = p.Header.CmdType
+	self.config.RequestHolder.AttachAsync(opaque, future)
+	self.enqueue(p, future)
+}
+
+//创建future并写入队列
+func (self *TClient) writeFuture(pp *Packet, timeout time.Duration) (*Future, chan time.Time) {
 	opaque := self.fillOpaque(pp)
-	future := NewFuture(opaque, timeout, self.localAddr, self.ctx)
+	future := NewFuture(opaque, timeout, self.remoteAddr, self.ctx)
+	future.cmdType = pp.Header.CmdType
 	tchan := self.config.RequestHolder.Attach(opaque, future)
+	self.enqueue(pp, future)
+	return future, tchan
+}
+
+//写入队列,写入失败则设置future的错误
+func (self *TClient) enqueue(pp *Packet, future *Future) {
 	//写入完成之后的操作
 	pp.OnComplete = func(err error) {
 		if nil != err {
@@ -196,51 +456,16 @@ func (self *TClient) WriteAndGet(p Packet,
 	}
 
 	//写入队列
+	atomic.AddInt32(&self.pending, 1)
 	select {
 	case self.wchan <- pp:
 	default:
-		err := errors.New(fmt.Sprintf("WRITE CHANNLE [%s] FULL", self.remoteAddr))
+		atomic.AddInt32(&self.pending, -1)
+		err := newTurboError(ErrKindBackpressure, ERR_WRITE_CHANNEL_FULL,
+			self.remoteAddr, pp.Header.Opaque, pp.Header.CmdType)
 		pp.OnComplete(err)
 		future.Error(err)
 	}
-	resp, err := future.Get(tchan)
-	return resp, err
-}
-
-//分组写入
-func (self *TClient) GroupWriteAndGet(timeout time.Duration, packets ...Packet) ([]*Future, error) {
-
-	futures := make([]*Future, 0, len(packets))
-	for i := range packets {
-		pp := &(packets[i])
-		opaque := self.fillOpaque(pp)
-		future := NewFuture(opaque, timeout, self.localAddr, self.ctx)
-
-		self.config.RequestHolder.Attach(opaque, future)
-		//写入完成之后的操作
-		pp.OnComplete = func(err error) {
-			if nil != err {
-				log.Errorf("TClient|Write|OnComplete|ERROR|FAIL|%v|%s", err, string(pp.Data))
-				future.Error(err)
-				//生成一个错误的转发
-				ctx := &TContext{
-					Client:  self,
-					Message: pp,
-					Err:     err}
-				self.dis(ctx)
-			}
-		}
-		//写入队列
-		select {
-		case self.wchan <- pp:
-		default:
-			err := errors.New(fmt.Sprintf("WRITE CHANNLE [%s] FULL", self.remoteAddr))
-			pp.OnComplete(err)
-			future.Error(err)
-		}
-		futures = append(futures, future)
-	}
-	return futures, nil
 }
 
 //只是写出去
@@ -260,73 +485,215 @@ func (self *TClient) Write(p Packet) error {
 	}
 
 	//写入队列
+	atomic.AddInt32(&self.pending, 1)
 	select {
 	case self.wchan <- &p:
 		return nil
 	default:
-		err := errors.New(fmt.Sprintf("WRITE CHANNLE [%s] FULL", self.remoteAddr))
+		atomic.AddInt32(&self.pending, -1)
+		err := newTurboError(ErrKindBackpressure, ERR_WRITE_CHANNEL_FULL,
+			self.remoteAddr, p.Header.Opaque, p.Header.CmdType)
 		p.OnComplete(err)
 		return err
 	}
 }
 
 //写入响应
-func (self *TClient) asyncWrite() {
-
+//写协程只写入启动时的session,session关闭之后退出
+func (self *TClient) asyncWrite(s *TSession) {
+	done := make(chan bool)
+	self.writerDone = done
 	go func() {
-		for !self.IsClosed() {
-
-			tid, timeout := self.config.TW.AddTimer(1*time.Second, nil, nil)
-			select {
-			case p := <-self.wchan:
-				//先读到数据，则取消定时
-				self.config.TW.CancelTimer(tid)
-				if nil != p {
-					//这里坐下序列化，看下Body是否大于最大的包大小
-					raw, err := self.codec().MarshalPayload(p)
-					if nil != err {
-						log.Errorf("TClient|asyncWrite|MarshalPayload|FAIL|%v|%+v",
-							err, p.PayLoad)
-						if nil != p.OnComplete {
-							p.OnComplete(err)
-						}
-						continue
-					} else if len(raw) > MAX_PACKET_BYTES {
-						log.Errorf("TClient|asyncWrite|MarshalPayload|FAIL|MAX_PACKET_BYTES|%d|%d",
-							len(raw), MAX_PACKET_BYTES)
-						if nil != p.OnComplete {
-							p.OnComplete(ERR_TOO_LARGE_PACKET)
-						}
-						continue
-					} else {
-
-						//设置数据
-						p.Data = raw
-						//其他的都OK
-					}
-					//批量写入
-					err = self.s.Write(p)
-					//链接是关闭的
-					if nil != err {
-						log.Errorf("TClient|asyncWrite|Write|FAIL|%v",
-							err)
-						self.s.Close()
-						continue
+		defer close(done)
+		batch := make([]*Packet, 0, MAX_WRITE_BATCH)
+		for !s.Closed() {
+			p := self.next(s)
+			if nil == p {
+				continue
+			}
+
+			//把已经入队的包合并写出,超过写缓冲大小或者队列空闲时写出
+			batch = batch[:0]
+			count := 1
+			size := 0
+			if self.prepare(s, p) {
+				batch = append(batch, p)
+				size += len(p.Data)
+			}
+		drain:
+			for count < MAX_WRITE_BATCH && size < self.config.WriteBufferSize {
+				select {
+				case p := <-self.wchan:
+					count++
+					if nil != p && self.prepare(s, p) {
+						batch = append(batch, p)
+						size += len(p.Data)
 					}
+				default:
+					break drain
 				}
-			case <-timeout:
-				//超时了
+			}
+
+			self.write0(s, batch)
+			atomic.AddInt32(&self.pending, -int32(count))
+			//不持有已经写出的包
+			for i := range batch {
+				batch[i] = nil
 			}
 		}
 	}()
 }
 
+//获取下一个待写出的包,普通的包优先于大包的分片
+//等待超时或者session关闭返回nil
+func (self *TClient) next(s *TSession) *Packet {
+	select {
+	case p := <-self.wchan:
+		return p
+	default:
+	}
+
+	tid, timeout := self.config.TW.AddTimer(1*time.Second, nil, nil)
+	select {
+	case p := <-self.wchan:
+		//先读到数据，则取消定时
+		self.config.TW.CancelTimer(tid)
+		return p
+	case p := <-self.schan:
+		self.config.TW.CancelTimer(tid)
+		return p
+	case <-timeout:
+		//超时了
+		return nil
+	case <-s.closed:
+		self.config.TW.CancelTimer(tid)
+		return nil
+	}
+}
+
+//序列化一个包,失败的直接回调OnComplete
+func (self *TClient) prepare(s *TSession, p *Packet) bool {
+	//这里坐下序列化，看下Body是否大于最大的包大小
+	var raw []byte
+	var err error
+	if IsControlCmd(p.Header.CmdType) {
+		//控制命令不经过codec
+		raw = p.Data
+	} else if p.Header.Extension&EXT_CHUNK != 0 {
+		//大包的分片已经是序列化之后的数据
+		raw, err = self.compress(p, p.Data)
+	} else {
+		raw, err = self.codec().MarshalPayload(p)
+		if nil == err {
+			raw, err = self.compress(p, raw)
+		}
+	}
+	if nil != err {
+		log.Errorf("TClient|asyncWrite|MarshalPayload|FAIL|%v|%+v",
+			err, p.PayLoad)
+		if nil != p.OnComplete {
+			p.OnComplete(newTurboError(ErrKindCodec, err, self.remoteAddr, p.Header.Opaque, p.Header.CmdType))
+		}
+		return false
+	} else if len(raw) > s.maxOutbound {
+		log.Errorf("TClient|asyncWrite|MarshalPayload|FAIL|MAX_PACKET_BYTES|%d|%d",
+			len(raw), s.maxOutbound)
+		if nil != p.OnComplete {
+			p.OnComplete(newTurboError(ErrKindTooLarge, ERR_TOO_LARGE_PACKET,
+				self.remoteAddr, p.Header.Opaque, p.Header.CmdType))
+		}
+		return false
+	}
+	//设置数据
+	p.Data = raw
+	return true
+}
+
+//批量写出
+func (self *TClient) write0(s *TSession, batch []*Packet) {
+	if len(batch) <= 0 {
+		return
+	}
+	err := s.Write(batch...)
+	//链接是关闭的
+	if nil != err {
+		log.Errorf("TClient|asyncWrite|Write|FAIL|%v",
+			err)
+		if !s.Closed() {
+			self.fireError(err)
+		}
+		s.closeWith(err)
+	}
+}
+
+//读写失败的回调
+func (self *TClient) fireError(err error) {
+	if nil != self.config.OnError {
+		self.config.OnError(self, err)
+	}
+}
+
+//超过阈值的body进行压缩并在Extension中标记压缩算法
+//帧格式携带不了压缩标记的不压缩
+func (self *TClient) compress(p *Packet, raw []byte) ([]byte, error) {
+	if !carryFlags(frameFormat(self.config)) {
+		return raw, nil
+	}
+	compressed, ok, err := compressBody(self.config.Compress, self.config.CompressThreshold, raw)
+	if nil != err {
+		return nil, err
+	}
+	if ok {
+		p.Header.SetCompress(self.config.Compress)
+	} else {
+		p.Header.SetCompress(COMPRESS_NONE)
+	}
+	return compressed, nil
+}
+
+//连接两端地址的字符串
+//unix socket没有host:port,使用合成的地址
+func connAddrs(conn net.Conn) (string, string) {
+	if _, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+		return unixConnAddrs(conn)
+	}
+	local, remote := addrString(conn.LocalAddr()), addrString(conn.RemoteAddr())
+	if local == remote {
+		//内存中的连接(net.Pipe)两端地址相同,加上序号保证在ClientManager和FlowStat中唯一
+		remote = local + "#" + strconv.FormatUint(uint64(atomic.AddUint32(&connSeq, 1)), 10)
+	}
+	return local, remote
+}
+
+//host:port,IPv6的地址为[host]:port,可以直接用于Dial
+func addrString(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		host := tcpAddr.IP.String()
+		if len(tcpAddr.Zone) > 0 {
+			host += "%" + tcpAddr.Zone
+		}
+		return net.JoinHostPort(host, strconv.Itoa(tcpAddr.Port))
+	}
+	return addr.String()
+}
+
 func (self *TClient) IsClosed() bool {
-	return self.s.Closed()
+	return self.session().Closed()
+}
+
+//连接关闭的原因,没有关闭返回nil
+//本地Shutdown为ERR_CLOSED_LOCAL,对端关闭为包装了io.EOF的TurboError
+func (self *TClient) CloseReason() error {
+	return self.session().CloseReason()
 }
 
 func (self *TClient) Shutdown() {
+	self.shutdown(ERR_CLOSED_LOCAL)
+}
+
+//按照指定的原因关闭
+func (self *TClient) shutdown(reason error) {
 	self.closeFunc()
-	self.s.Close()
-	log.Infof("TClient|Shutdown|%s...", self.RemoteAddr())
+	self.session().closeWith(reason)
+	log.Infof("TClient|Shutdown|%s|%v...", self.RemoteAddr(), reason)
 }
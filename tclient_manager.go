@@ -67,6 +67,14 @@ func (self *ClientManager) Validate(client *TClient) bool {
 }
 
 func (self *ClientManager) Auth(auth *GroupAuth, client *TClient) bool {
+	self.auth(auth, client)
+	if nil != client.config.OnAuth {
+		client.config.OnAuth(client, auth)
+	}
+	return true
+}
+
+func (self *ClientManager) auth(auth *GroupAuth, client *TClient) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
 
@@ -80,7 +88,6 @@ func (self *ClientManager) Auth(auth *GroupAuth, client *TClient) bool {
 	self.groupAuth[client.RemoteAddr()] = auth
 	self.groupClients[auth.GroupId] = append(cs, client)
 	self.allClients[client.RemoteAddr()] = client
-	return true
 }
 
 func (self *ClientManager) ClientsClone() map[string]*TClient {
@@ -212,6 +219,10 @@ func (self *ClientManager) FindTClients(groupIds []string, filter func(groupId s
 				closedClients[c.remoteAddr] = c
 				continue
 			}
+			//对端即将关闭的不再发送新的请求
+			if c.IsGoaway() {
+				continue
+			}
 			//如果当前client处于非关闭状态并且没有过滤则入选
 			if !filter(gid, c) {
 
@@ -1,8 +1,8 @@
 package turbo
 
 import (
+	"context"
 	"math"
-	"net"
 	"sync"
 	"time"
 )
@@ -28,16 +28,12 @@ func newReconnectTasK(remoteClient *TClient, ga *GroupAuth, finishHook func(addr
 func (self *reconnectTask) reconnect(handshake func(ga *GroupAuth, remoteClient *TClient) (bool, error)) (bool, error) {
 
 	self.retryCount++
-	//开启remoteClient的重连任务
-	tcpAddr, _ := net.ResolveTCPAddr("tcp4", self.remoteClient.RemoteAddr())
-	conn, err := net.DialTCP("tcp4", nil, tcpAddr)
+	//使用Dial时的地址和参数重新建立连接,支持tls和unix socket
+	err := self.remoteClient.connect(context.Background())
 	if nil != err {
 		log.Errorf("TClient|RECONNECT|%s|FAIL|%s", self.remoteClient.RemoteAddr(), err)
 		return false, err
 	}
-	//重新设置conn
-	self.remoteClient.conn = conn
-	self.remoteClient.Start()
 	return handshake(self.ga, self.remoteClient)
 }
 
@@ -0,0 +1,307 @@
+package turbo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//创建server和连接到server的client
+//setup在server启动之前修改server的配置
+func newTestPair(t *testing.T, hostport string, handler THandler, setup ...func(config *TConfig)) (*TServer, *TClient) {
+	config := NewTConfig(
+		"turbo-server:"+hostport,
+		100, 16*1024,
+		16*1024, 100, 100,
+		10*time.Second,
+		1000)
+	for _, f := range setup {
+		f(config)
+	}
+	server := NewTServer(hostport, config, handler)
+	if err := server.ListenAndServer(); nil != err {
+		t.Fatal(err)
+	}
+
+	conn, err := dial(hostport)
+	if nil != err {
+		t.Fatal(err)
+	}
+	client := NewTClient(context.Background(), conn, func() ICodec {
+		return LengthBytesCodec{MaxFrameLength: MAX_PACKET_BYTES}
+	}, func(ctx *TContext) error {
+		ctx.Client.Attach(ctx.Message.Header.Opaque, ctx.Message.Data)
+		return nil
+	}, NewTConfig(
+		"turbo-client:"+hostport,
+		100, 16*1024,
+		16*1024, 100, 100,
+		10*time.Second,
+		1000))
+	client.Start()
+	t.Cleanup(func() {
+		client.Shutdown()
+		server.Shutdown(context.Background())
+	})
+	return server, client
+}
+
+//延迟delay之后echo
+func delayEcho(delay time.Duration) THandler {
+	return func(ctx *TContext) error {
+		if nil != ctx.Err {
+			return nil
+		}
+		p := ctx.Message
+		time.Sleep(delay)
+		resp := NewRespPacket(p.Header.Opaque, p.Header.CmdType, nil)
+		resp.PayLoad = p.Data
+		ctx.Client.Write(*resp)
+		return nil
+	}
+}
+
+func TestWriteAndGetContext(t *testing.T) {
+	_, client := newTestPair(t, "localhost:28892", delayEcho(200*time.Millisecond))
+
+	p := NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	resp, err := client.WriteAndGetContext(context.Background(), p)
+	if nil != err || string(resp.([]byte)) != "echo" {
+		t.Fatalf("unexpected response %v|%v", resp, err)
+	}
+
+	//ctx的deadline早于配置的超时
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	p = NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	_, err = client.WriteAndGetContext(ctx, p)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded %v", err)
+	}
+	if client.config.RequestHolder.holder.Contains(p.Header.Opaque) {
+		t.Fatal("future should be removed from holder")
+	}
+
+	//主动取消
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	p = NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	_, err = client.WriteAndGetContext(ctx, p)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect canceled %v", err)
+	}
+
+	//配置的超时早于ctx的deadline
+	client.config.RequestTimeout = 50 * time.Millisecond
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	p = NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	_, err = client.WriteAndGetContext(ctx, p)
+	if !errors.Is(err, ERR_TIMEOUT) {
+		t.Fatalf("expect timeout %v", err)
+	}
+}
+
+func TestWriteAsync(t *testing.T) {
+	_, client := newTestPair(t, "localhost:28893", delayEcho(100*time.Millisecond))
+
+	type result struct {
+		resp interface{}
+		err  error
+	}
+	results := make(chan result, 10)
+	for i := 0; i < 5; i++ {
+		p := NewPacket(1, nil)
+		p.PayLoad = []byte("echo")
+		client.WriteAsync(p, time.Second, func(resp interface{}, err error) {
+			results <- result{resp, err}
+		})
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case r := <-results:
+			if nil != r.err || string(r.resp.([]byte)) != "echo" {
+				t.Fatalf("unexpected response %v|%v", r.resp, r.err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("callback not fired")
+		}
+	}
+
+	//超时由时间轮触发回调
+	p := NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	client.WriteAsync(p, 30*time.Millisecond, func(resp interface{}, err error) {
+		results <- result{resp, err}
+	})
+	select {
+	case r := <-results:
+		if !errors.Is(r.err, ERR_TIMEOUT) {
+			t.Fatalf("expect timeout %v", r.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout callback not fired")
+	}
+
+	//超时之后的响应不会再回调
+	select {
+	case r := <-results:
+		t.Fatalf("callback fired twice %v|%v", r.resp, r.err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+//pool已满时异步回调不阻塞调用方,响应到达之后取消超时定时器
+func TestAsyncFuture(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool := NewLimitPool(ctx, 1)
+	block := make(chan bool)
+	defer close(block)
+	pool.Queue(ctx, func(ctx context.Context) (interface{}, error) {
+		<-block
+		return nil, nil
+	})
+
+	fired := make(chan error, 1)
+	future := NewAsyncFuture(1, time.Second, "", ctx, pool, func(resp interface{}, err error) {
+		fired <- err
+	})
+	go future.Error(ERR_TIMEOUT)
+	select {
+	case err := <-fired:
+		if err != ERR_TIMEOUT {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("callback blocked by a full pool")
+	}
+
+	holder := NewTConfig("async-future", 10, 16*1024, 16*1024, 10, 10, time.Second, 10).RequestHolder
+	future = NewAsyncFuture(2, time.Second, "", ctx, nil, func(resp interface{}, err error) {})
+	holder.AttachAsync(2, future)
+	if atomic.LoadUint32(&future.timerId) == 0 {
+		t.Fatal("async future should have a timer")
+	}
+	holder.Detach(2, "ok")
+	if atomic.LoadUint32(&future.timerId) != 0 {
+		t.Fatal("timer should be cancelled after the response")
+	}
+}
+
+func TestReply(t *testing.T) {
+	_, client := newTestPair(t, "localhost:28899", func(ctx *TContext) error {
+		if nil != ctx.Err {
+			return nil
+		}
+		return ctx.Reply(append([]byte("reply:"), ctx.Message.Data...))
+	})
+	//响应包不经过处理器
+	client.dis = func(ctx *TContext) error {
+		t.Errorf("response should not be dispatched %+v", ctx.Message.Header)
+		return nil
+	}
+
+	p := NewPacket(1, nil)
+	p.Header.Version = 2
+	p.PayLoad = []byte("echo")
+	resp, err := client.WriteAndGet(*p, time.Second)
+	if nil != err || string(resp.([]byte)) != "reply:echo" {
+		t.Fatalf("unexpected response %v|%v", resp, err)
+	}
+
+	//错误响应
+	config := NewTConfig("reply", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100)
+	c := NewTClient(context.Background(), nil, nil, nil, config)
+	ctx := &TContext{Message: NewRespPacket(10, 1, nil), Client: c}
+	ctx.ReplyError(newTurboError(ErrKindCodec, ERR_MARSHAL_PACKET, "", 10, 1))
+	ep := <-c.wchan
+	code, _, err := ParseErrorPacket(ep)
+	if nil != err || code != ERR_CODE_CODEC || !ep.Header.IsResponse() || ep.Header.Opaque != 10 {
+		t.Fatalf("unexpected error packet %d|%v|%+v", code, err, ep.Header)
+	}
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	var lock sync.Mutex
+	var connected, closed []*TClient
+	var reasons, errs []error
+	server, client := newTestPair(t, "localhost:28908", delayEcho(0), func(config *TConfig) {
+		config.MaxInboundFrame = 16
+		config.OnConnect = func(c *TClient) {
+			lock.Lock()
+			defer lock.Unlock()
+			connected = append(connected, c)
+		}
+		config.OnError = func(c *TClient, err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			errs = append(errs, err)
+		}
+		config.OnClose = func(c *TClient, reason error) {
+			lock.Lock()
+			defer lock.Unlock()
+			closed = append(closed, c)
+			reasons = append(reasons, reason)
+		}
+	})
+
+	if !waitFor(time.Second, func() bool { return len(server.Clients()) == 1 }) {
+		t.Fatal("server should accept the client")
+	}
+	lock.Lock()
+	if len(connected) != 1 || connected[0] != server.Clients()[0] {
+		lock.Unlock()
+		t.Fatalf("OnConnect should be called with the accepted client %v", connected)
+	}
+	lock.Unlock()
+
+	//超过限制的包
+	p := NewPacket(1, nil)
+	p.PayLoad = make([]byte, 32)
+	if _, err := client.WriteAndGet(*p, time.Second); !errors.Is(err, ERR_REMOTE) {
+		t.Fatalf("oversize packet should fail remotely %v", err)
+	}
+	lock.Lock()
+	if len(errs) != 1 || ErrorKindOf(errs[0]) != ErrKindTooLarge {
+		lock.Unlock()
+		t.Fatalf("OnError should report the oversize packet %v", errs)
+	}
+	lock.Unlock()
+
+	//对端关闭
+	client.Shutdown()
+	if !errors.Is(client.CloseReason(), ERR_CLOSED_LOCAL) {
+		t.Fatalf("local close reason %v", client.CloseReason())
+	}
+	if !waitFor(time.Second, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(closed) == 1
+	}) {
+		t.Fatal("OnClose should be called")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if closed[0] != connected[0] || !errors.Is(reasons[0], io.EOF) || ErrorKindOf(reasons[0]) != ErrKindBroken {
+		t.Fatalf("unexpected close reason %v", reasons[0])
+	}
+	if closed[0].CloseReason() != reasons[0] {
+		t.Fatalf("CloseReason should match the hook %v", closed[0].CloseReason())
+	}
+	//对端正常关闭不是错误
+	if len(errs) != 1 {
+		t.Fatalf("EOF should not be reported as error %v", errs)
+	}
+}
@@ -28,6 +28,25 @@ type ICodec interface {
 	MarshalPayload(p *Packet) ([]byte, error)
 }
 
+//codec可以实现该接口限制最大的包大小
+//连接的收发上限取TConfig和codec中较小的
+type FrameLimiter interface {
+	MaxFrameBytes() int
+}
+
+//连接的收发上限,取配置和codec中较小的,都没有配置的使用MAX_PACKET_BYTES
+func frameLimit(limit int, codec ICodec) int {
+	if limit <= 0 {
+		limit = MAX_PACKET_BYTES
+	}
+	if l, ok := codec.(FrameLimiter); ok {
+		if max := l.MaxFrameBytes(); max > 0 && max < limit {
+			limit = max
+		}
+	}
+	return limit
+}
+
 type LengthBytesCodec struct {
 	ICodec
 	MaxFrameLength int32 //最大的包大小
@@ -38,6 +57,11 @@ func (self LengthBytesCodec) UnmarshalPayload(p *Packet) (interface{}, error) {
 	return p.Data, nil
 }
 
+//最大的包大小,0为不限制
+func (self LengthBytesCodec) MaxFrameBytes() int {
+	return int(self.MaxFrameLength)
+}
+
 //序列化
 func (self LengthBytesCodec) MarshalPayload(packet *Packet) ([]byte, error) {
 	if raw, ok := packet.PayLoad.([]byte); ok {
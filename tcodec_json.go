@@ -0,0 +1,61 @@
+package turbo
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+//json的codec
+//UnmarshalPayload按照CmdType注册的类型返回对应的指针,没有注册的返回json.RawMessage
+//MarshalPayload接受任意可以json序列化的PayLoad,[]byte原样写出
+type JsonCodec struct {
+	MaxFrameLength int32 //最大的包大小
+	types          map[uint8]reflect.Type
+}
+
+func NewJsonCodec() *JsonCodec {
+	return &JsonCodec{
+		MaxFrameLength: MAX_PACKET_BYTES,
+		types:          make(map[uint8]reflect.Type)}
+}
+
+//注册CmdType对应的消息类型,msg可以是值或者指针,需要在创建连接之前调用
+func (self *JsonCodec) Register(cmdType uint8, msg interface{}) *JsonCodec {
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	self.types[cmdType] = t
+	return self
+}
+
+//反序列化
+func (self *JsonCodec) UnmarshalPayload(p *Packet) (interface{}, error) {
+	t, ok := self.types[p.Header.CmdType]
+	if !ok {
+		return json.RawMessage(p.Data), nil
+	}
+	msg := reflect.New(t).Interface()
+	if err := json.Unmarshal(p.Data, msg); nil != err {
+		return nil, fmt.Errorf("%w|cmd:%d|%v", ERR_INVALID_PAYLOAD, p.Header.CmdType, err)
+	}
+	return msg, nil
+}
+
+//序列化
+func (self *JsonCodec) MarshalPayload(p *Packet) ([]byte, error) {
+	if raw, ok := p.PayLoad.([]byte); ok {
+		return raw, nil
+	}
+	raw, err := json.Marshal(p.PayLoad)
+	if nil != err {
+		return nil, fmt.Errorf("%w|cmd:%d|%v", ERR_MARSHAL_PACKET, p.Header.CmdType, err)
+	}
+	return raw, nil
+}
+
+//最大的包大小,0为不限制
+func (self *JsonCodec) MaxFrameBytes() int {
+	return int(self.MaxFrameLength)
+}
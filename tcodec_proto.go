@@ -0,0 +1,61 @@
+package turbo
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+//protobuf的codec
+//UnmarshalPayload按照CmdType注册的消息类型返回具体的proto.Message
+//MarshalPayload接受proto.Message类型的PayLoad,[]byte原样写出
+type ProtoCodec struct {
+	MaxFrameLength int32 //最大的包大小
+	types          map[uint8]protoreflect.MessageType
+}
+
+func NewProtoCodec() *ProtoCodec {
+	return &ProtoCodec{
+		MaxFrameLength: MAX_PACKET_BYTES,
+		types:          make(map[uint8]protoreflect.MessageType)}
+}
+
+//注册CmdType对应的消息类型,需要在创建连接之前调用
+func (self *ProtoCodec) Register(cmdType uint8, msg proto.Message) *ProtoCodec {
+	self.types[cmdType] = msg.ProtoReflect().Type()
+	return self
+}
+
+//反序列化
+func (self *ProtoCodec) UnmarshalPayload(p *Packet) (interface{}, error) {
+	t, ok := self.types[p.Header.CmdType]
+	if !ok {
+		return nil, fmt.Errorf("%w|cmd:%d|unregistered message type", ERR_INVALID_PAYLOAD, p.Header.CmdType)
+	}
+	msg := t.New().Interface()
+	if err := proto.Unmarshal(p.Data, msg); nil != err {
+		return nil, fmt.Errorf("%w|cmd:%d|%v", ERR_INVALID_PAYLOAD, p.Header.CmdType, err)
+	}
+	return msg, nil
+}
+
+//序列化
+func (self *ProtoCodec) MarshalPayload(p *Packet) ([]byte, error) {
+	switch payload := p.PayLoad.(type) {
+	case []byte:
+		return payload, nil
+	case proto.Message:
+		raw, err := proto.Marshal(payload)
+		if nil != err {
+			return nil, fmt.Errorf("%w|cmd:%d|%v", ERR_MARSHAL_PACKET, p.Header.CmdType, err)
+		}
+		return raw, nil
+	}
+	return nil, fmt.Errorf("%w|cmd:%d|%T", ERR_INVALID_PAYLOAD, p.Header.CmdType, p.PayLoad)
+}
+
+//最大的包大小,0为不限制
+func (self *ProtoCodec) MaxFrameBytes() int {
+	return int(self.MaxFrameLength)
+}
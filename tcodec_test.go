@@ -0,0 +1,74 @@
+package turbo
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type jsonMessage struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestJsonCodec(t *testing.T) {
+	codec := NewJsonCodec().Register(1, jsonMessage{})
+
+	p := NewPacket(1, nil)
+	p.PayLoad = &jsonMessage{Name: "turbo", Age: 1}
+	raw, err := codec.MarshalPayload(p)
+	if nil != err {
+		t.Fatal(err)
+	}
+	p.Data = raw
+	msg, err := codec.UnmarshalPayload(p)
+	if nil != err || *msg.(*jsonMessage) != (jsonMessage{Name: "turbo", Age: 1}) {
+		t.Fatalf("unexpected message %v|%v", msg, err)
+	}
+
+	//没有注册的CmdType返回原始的json
+	p.Header.CmdType = 2
+	msg, err = codec.UnmarshalPayload(p)
+	if _, ok := msg.(json.RawMessage); !ok || nil != err {
+		t.Fatalf("unexpected message %T|%v", msg, err)
+	}
+
+	p.Header.CmdType = 1
+	p.Data = []byte("{")
+	if _, err := codec.UnmarshalPayload(p); !errors.Is(err, ERR_INVALID_PAYLOAD) {
+		t.Fatalf("expect invalid payload %v", err)
+	}
+	if frameLimit(0, codec) != MAX_PACKET_BYTES {
+		t.Fatal("unexpected frame limit")
+	}
+}
+
+func TestProtoCodec(t *testing.T) {
+	codec := NewProtoCodec().Register(1, &wrapperspb.StringValue{})
+
+	p := NewPacket(1, nil)
+	p.PayLoad = wrapperspb.String("turbo")
+	raw, err := codec.MarshalPayload(p)
+	if nil != err {
+		t.Fatal(err)
+	}
+	p.Data = raw
+	msg, err := codec.UnmarshalPayload(p)
+	if nil != err || !proto.Equal(msg.(*wrapperspb.StringValue), wrapperspb.String("turbo")) {
+		t.Fatalf("unexpected message %v|%v", msg, err)
+	}
+
+	//没有注册的CmdType
+	p.Header.CmdType = 2
+	if _, err := codec.UnmarshalPayload(p); !errors.Is(err, ERR_INVALID_PAYLOAD) {
+		t.Fatalf("expect invalid payload %v", err)
+	}
+	//不是proto.Message的PayLoad
+	p.PayLoad = "turbo"
+	if _, err := codec.MarshalPayload(p); !errors.Is(err, ERR_INVALID_PAYLOAD) {
+		t.Fatalf("expect invalid payload %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package turbo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+var ERR_UNKNOWN_COMPRESS = errors.New("UNKNOWN COMPRESS TYPE")
+
+//默认超过1KB才压缩
+const DEFAULT_COMPRESS_THRESHOLD = 1024
+
+//压缩算法
+type Compressor interface {
+	Compress(src []byte) ([]byte, error)
+	//maxLen为解压之后允许的最大长度
+	Decompress(src []byte, maxLen int) ([]byte, error)
+}
+
+var compressors = map[Compress]Compressor{
+	COMPRESS_GZIP:   &gzipCompressor{},
+	COMPRESS_SNAPPY: snappyCompressor{},
+	COMPRESS_ZSTD:   newZstdCompressor(),
+}
+
+//注册或者替换压缩算法,需要在创建连接之前调用
+func RegisterCompressor(c Compress, compressor Compressor) {
+	compressors[c] = compressor
+}
+
+//压缩body,返回的bool表示是否压缩
+//压缩之后没有变小的不压缩
+func compressBody(c Compress, threshold int, raw []byte) ([]byte, bool, error) {
+	if c == COMPRESS_NONE || len(raw) < threshold {
+		return raw, false, nil
+	}
+	compressor, ok := compressors[c]
+	if !ok {
+		return raw, false, fmt.Errorf("%w|%d", ERR_UNKNOWN_COMPRESS, c)
+	}
+	compressed, err := compressor.Compress(raw)
+	if nil != err {
+		return raw, false, err
+	}
+	if len(compressed) >= len(raw) {
+		return raw, false, nil
+	}
+	return compressed, true, nil
+}
+
+//解压body
+func decompressBody(c Compress, raw []byte, maxLen int) ([]byte, error) {
+	compressor, ok := compressors[c]
+	if !ok {
+		return nil, fmt.Errorf("%w|%d", ERR_UNKNOWN_COMPRESS, c)
+	}
+	return compressor.Decompress(raw, maxLen)
+}
+
+//------------gzip
+type gzipCompressor struct {
+	writers sync.Pool
+}
+
+func (self *gzipCompressor) Compress(src []byte) ([]byte, error) {
+	var buff bytes.Buffer
+	w, ok := self.writers.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(&buff)
+	} else {
+		w = gzip.NewWriter(&buff)
+	}
+	defer self.writers.Put(w)
+
+	if _, err := w.Write(src); nil != err {
+		return nil, err
+	}
+	if err := w.Close(); nil != err {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (self *gzipCompressor) Decompress(src []byte, maxLen int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if nil != err {
+		return nil, err
+	}
+	defer r.Close()
+	//多读一个字节判断是否超过最大长度
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(maxLen)+1))
+	if nil != err {
+		return nil, err
+	}
+	if len(data) > maxLen {
+		return nil, ERR_TOO_LARGE_PACKET
+	}
+	return data, nil
+}
+
+//------------snappy
+type snappyCompressor struct{}
+
+func (self snappyCompressor) Compress(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (self snappyCompressor) Decompress(src []byte, maxLen int) ([]byte, error) {
+	l, err := snappy.DecodedLen(src)
+	if nil != err {
+		return nil, err
+	}
+	if l > maxLen {
+		return nil, ERR_TOO_LARGE_PACKET
+	}
+	return snappy.Decode(nil, src)
+}
+
+//------------zstd
+//EncodeAll是并发安全的,共享一个encoder
+//解压使用流式的decoder配合LimitReader,没有原始长度的帧也不会解压出超过maxLen的数据
+//流式的decoder不是并发安全的,放在pool中复用
+type zstdCompressor struct {
+	encoder  *zstd.Encoder
+	decoders sync.Pool
+}
+
+func newZstdCompressor() *zstdCompressor {
+	encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	return &zstdCompressor{encoder: encoder}
+}
+
+func (self *zstdCompressor) Compress(src []byte) ([]byte, error) {
+	return self.encoder.EncodeAll(src, nil), nil
+}
+
+func (self *zstdCompressor) Decompress(src []byte, maxLen int) ([]byte, error) {
+	//帧头中带有原始长度的先校验,避免无谓的解压
+	var h zstd.Header
+	if nil == h.Decode(src) && h.HasFCS && h.FrameContentSize > uint64(maxLen) {
+		return nil, ERR_TOO_LARGE_PACKET
+	}
+
+	var err error
+	r, ok := self.decoders.Get().(*zstd.Decoder)
+	if ok {
+		err = r.Reset(bytes.NewReader(src))
+	} else {
+		//单协程同步解压,不会启动后台goroutine,放回pool不需要Close
+		r, err = zstd.NewReader(bytes.NewReader(src), zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(uint64(MAX_INT32)))
+	}
+	if nil != err {
+		return nil, err
+	}
+	defer func() {
+		r.Reset(nil)
+		self.decoders.Put(r)
+	}()
+
+	//多读一个字节判断是否超过最大长度
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(maxLen)+1))
+	if nil != err {
+		return nil, err
+	}
+	if len(data) > maxLen {
+		return nil, ERR_TOO_LARGE_PACKET
+	}
+	return data, nil
+}
@@ -0,0 +1,88 @@
+package turbo
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressor(t *testing.T) {
+	raw := bytes.Repeat([]byte("turbo compress "), 1000)
+	for _, c := range []Compress{COMPRESS_GZIP, COMPRESS_SNAPPY, COMPRESS_ZSTD} {
+		compressed, ok, err := compressBody(c, DEFAULT_COMPRESS_THRESHOLD, raw)
+		if nil != err || !ok || len(compressed) >= len(raw) {
+			t.Fatalf("compress %d fail %v|%v|%d", c, err, ok, len(compressed))
+		}
+		data, err := decompressBody(c, compressed, MAX_PACKET_BYTES)
+		if nil != err || !bytes.Equal(data, raw) {
+			t.Fatalf("decompress %d fail %v", c, err)
+		}
+		//解压之后超过最大长度
+		if _, err := decompressBody(c, compressed, 100); !errors.Is(err, ERR_TOO_LARGE_PACKET) {
+			t.Fatalf("decompress %d should be too large %v", c, err)
+		}
+	}
+
+	//小于阈值不压缩
+	if _, ok, _ := compressBody(COMPRESS_GZIP, DEFAULT_COMPRESS_THRESHOLD, []byte("small")); ok {
+		t.Fatal("small body should not be compressed")
+	}
+	if _, _, err := compressBody(Compress(7), 0, raw); !errors.Is(err, ERR_UNKNOWN_COMPRESS) {
+		t.Fatalf("unknown compress %v", err)
+	}
+}
+
+//帧头中没有原始长度的zstd数据也不能解压超过最大长度
+func TestZstdWithoutContentSize(t *testing.T) {
+	raw := make([]byte, 4*1024*1024)
+	var buff bytes.Buffer
+	w, _ := zstd.NewWriter(&buff)
+	w.Write(raw)
+	w.Close()
+	var h zstd.Header
+	if err := h.Decode(buff.Bytes()); nil != err || h.HasFCS {
+		t.Fatalf("stream frame should not carry content size %v|%v", err, h.HasFCS)
+	}
+
+	if _, err := decompressBody(COMPRESS_ZSTD, buff.Bytes(), 1024); !errors.Is(err, ERR_TOO_LARGE_PACKET) {
+		t.Fatalf("expect too large %v", err)
+	}
+	data, err := decompressBody(COMPRESS_ZSTD, buff.Bytes(), len(raw))
+	if nil != err || !bytes.Equal(data, raw) {
+		t.Fatalf("decompress fail %v|%d", err, len(data))
+	}
+}
+
+func TestHeaderCompressFlag(t *testing.T) {
+	h := PacketHeader{Extension: 12345}
+	h.SetCompress(COMPRESS_ZSTD)
+	if h.Compress() != COMPRESS_ZSTD || h.Extension&^EXT_COMPRESS_MASK != 12345 {
+		t.Fatalf("unexpected extension %x", h.Extension)
+	}
+	h.SetCompress(COMPRESS_NONE)
+	if h.Extension != 12345 {
+		t.Fatalf("unexpected extension %x", h.Extension)
+	}
+}
+
+func TestCompressEcho(t *testing.T) {
+	_, client := newTestPair(t, "localhost:28895", delayEcho(0), func(config *TConfig) {
+		config.Compress = COMPRESS_ZSTD
+	})
+	client.config.Compress = COMPRESS_GZIP
+
+	raw := bytes.Repeat([]byte("turbo compress "), 1000)
+	p := NewPacket(1, nil)
+	p.PayLoad = raw
+	resp, err := client.WriteAndGet(*p, time.Second)
+	if nil != err || !bytes.Equal(resp.([]byte), raw) {
+		t.Fatalf("unexpected response %v", err)
+	}
+	stat := client.config.FlowStat.Stat()
+	if stat.WriteBytes >= int32(len(raw)) {
+		t.Fatalf("body not compressed %d", stat.WriteBytes)
+	}
+}
@@ -2,6 +2,7 @@ package turbo
 
 import (
 	"context"
+	"io"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -9,6 +10,10 @@ import (
 
 const (
 	CONCURRENT_LEVEL = 8
+	//默认请求超时
+	DEFAULT_REQUEST_TIMEOUT = 5 * time.Second
+	//默认监听和连接的网络,同时支持IPv4和IPv6
+	DEFAULT_NETWORK = "tcp"
 )
 
 //-----------响应的future
@@ -22,6 +27,11 @@ type Future struct {
 	TargetHost string
 	Err        error
 	ctx        context.Context
+	cmdType    uint8
+	//异步回调,为空则是同步等待的future
+	callback func(resp interface{}, err error)
+	pool     *GPool
+	timerId  uint32 //异步future的超时定时器,atomic访问
 }
 
 func NewFuture(opaque uint32, timeout time.Duration, targetHost string, ctx context.Context) *Future {
@@ -47,10 +57,40 @@ func NewErrFuture(opaque uint32, targetHost string, err error, ctx context.Conte
 	return f
 }
 
+//创建异步回调的future
+//响应或者错误到达时在pool中执行callback
+func NewAsyncFuture(opaque uint32, timeout time.Duration, targetHost string, ctx context.Context,
+	pool *GPool, callback func(resp interface{}, err error)) *Future {
+	f := NewFuture(opaque, timeout, targetHost, ctx)
+	f.callback = callback
+	f.pool = pool
+	return f
+}
+
+//带上请求信息的错误
+func (f *Future) typedError(kind ErrorKind, err error) error {
+	return newTurboError(kind, err, f.TargetHost, f.opaque, f.cmdType)
+}
+
 func (f *Future) Error(err error) {
 	f.once.Do(func() {
 		f.Err = err
 		close(f.ch)
+		if nil != f.callback {
+			//超时、写失败等错误在定时器或者写协程中触发，投递到pool中回调
+			if nil == f.pool {
+				f.callback(nil, err)
+				return
+			}
+			//调用方可能是时间轮、读协程或者pool本身,不能阻塞等待pool的空闲协程
+			_, qerr := f.pool.TryQueue(context.Background(), func(ctx context.Context) (interface{}, error) {
+				f.callback(nil, err)
+				return nil, nil
+			})
+			if nil != qerr {
+				go f.callback(nil, err)
+			}
+		}
 	})
 
 }
@@ -59,18 +99,41 @@ func (f *Future) SetResponse(resp interface{}) {
 	f.once.Do(func() {
 		f.response = resp
 		close(f.ch)
+		if nil != f.callback {
+			//响应是在分发的pool中Attach的，直接回调
+			f.callback(resp, nil)
+		}
 	})
 }
 
+//带上下文的等待
+//ctx结束返回ctx.Err(),timeout为nil则只由ctx决定超时
+func (f *Future) GetContext(ctx context.Context, timeout <-chan time.Time) (interface{}, error) {
+
+	select {
+	case <-ctx.Done():
+		f.Error(ctx.Err())
+		return f.response, f.Err
+	case <-timeout:
+		f.Error(f.typedError(ErrKindTimeout, ERR_TIMEOUT))
+		return f.response, f.Err
+	case <-f.ctx.Done():
+		f.Error(f.typedError(ErrKindBroken, ERR_CONNECTION_BROKEN))
+		return f.response, f.Err
+	case <-f.ch:
+		return f.response, f.Err
+	}
+}
+
 func (f *Future) Get(timeout <-chan time.Time) (interface{}, error) {
 
 	select {
 	case <-timeout:
 		//如果是已经超时了但是当前还是没有响应也认为超时
-		f.Error(ERR_TIMEOUT)
+		f.Error(f.typedError(ErrKindTimeout, ERR_TIMEOUT))
 		return f.response, f.Err
 	case <-f.ctx.Done():
-		f.Error(ERR_CONNECTION_BROKEN)
+		f.Error(f.typedError(ErrKindBroken, ERR_CONNECTION_BROKEN))
 		return f.response, f.Err
 	case <-f.ch:
 		return f.response, f.Err
@@ -79,16 +142,42 @@ func (f *Future) Get(timeout <-chan time.Time) (interface{}, error) {
 
 //网络层参数
 type TConfig struct {
-	FlowStat         *RemotingFlow //网络层流量
-	dispool          *GPool        //   最大分发处理协程数
-	ReadBufferSize   int           //读取缓冲大小
-	WriteBufferSize  int           //写入缓冲大小
-	WriteChannelSize int           //写异步channel长度
-	ReadChannelSize  int           //读异步channel长度
-	IdleTime         time.Duration //连接空闲时间
-	RequestHolder    *ReqHolder
-	TW               *TimerWheel // timewheel
-	cancel           context.CancelFunc
+	FlowStat            *RemotingFlow //网络层流量
+	Network             string        //tcp同时支持IPv4和IPv6,tcp4、tcp6只使用对应的协议族
+	dispool             *GPool        //   最大分发处理协程数
+	ReadBufferSize      int           //读取缓冲大小
+	WriteBufferSize     int           //写入缓冲大小
+	WriteChannelSize    int           //写异步channel长度
+	ReadChannelSize     int           //读异步channel长度
+	IdleTime            time.Duration //连接空闲时间
+	RequestTimeout      time.Duration //WriteAndGetContext默认的请求超时
+	RequestHolder       *ReqHolder
+	TW                  *TimerWheel   // timewheel
+	FrameFormat         FrameFormat   //包头的帧格式
+	Compress            Compress      //body的压缩算法,默认不压缩
+	CompressThreshold   int           //body超过该字节数才压缩
+	MaxInboundFrame     int           //接收的最大包体字节数
+	MaxOutboundFrame    int           //发送的最大包体字节数
+	Heartbeat           bool          //是否开启心跳检查,空闲IdleTime之后发送ping
+	MaxMissedHeartbeats int           //连续没有响应的心跳次数超过该值则关闭连接
+	MaxIdleTime         time.Duration //服务端关闭超过该时间没有收发的连接,0为不关闭
+	MaxConnAge          time.Duration //服务端连接的最长存活时间,超过之后GOAWAY并关闭,0为不限制
+	MaxConnections      int           //服务端最大连接数,0为不限制
+	MaxConnectionsPerIP int           //服务端每个来源IP的最大连接数,0为不限制
+	AcceptRate          int           //服务端每秒accept的连接数,0为不限制
+	AcceptBurst         int           //accept的突发连接数,默认和AcceptRate相同
+	StreamChunkSize     int           //大包每个分片的大小
+	MaxStreamBytes      int           //接收的大包合并之后的最大字节数,StreamHandler为还没有读取的最大字节数
+	MaxStreams          int           //每个连接同时接收的大包数,0为不限制
+	StreamIdleTimeout   time.Duration //超过该时间没有收到分片的大包中断接收,0为不检查
+	//不为空则大包以io.Reader的方式交给StreamHandler处理,不再合并
+	StreamHandler func(ctx *TContext, body io.Reader) error
+	//连接的生命周期回调,服务端和客户端的连接都会触发,不能阻塞
+	OnConnect func(c *TClient)                  //连接建立,Start之后
+	OnAuth    func(c *TClient, auth *GroupAuth) //ClientManager授权成功
+	OnError   func(c *TClient, err error)       //读写、解码失败
+	OnClose   func(c *TClient, reason error)    //连接关闭,reason为关闭的原因
+	cancel    context.CancelFunc
 }
 
 func NewTConfig(name string,
@@ -111,20 +200,40 @@ func NewTConfig(name string,
 	dispool := NewLimitPool(ctx, maxdispatcherNum)
 	//初始化
 	rc := &TConfig{
-		FlowStat:         NewRemotingFlow(name, dispool),
-		dispool:          dispool,
-		ReadBufferSize:   readbuffersize,
-		WriteBufferSize:  writebuffersize,
-		WriteChannelSize: writechannlesize,
-		ReadChannelSize:  readchannelsize,
-		IdleTime:         idletime,
-		RequestHolder:    rh,
-		TW:               tw,
-		cancel:           cancel,
+		FlowStat:            NewRemotingFlow(name, dispool),
+		dispool:             dispool,
+		ReadBufferSize:      readbuffersize,
+		WriteBufferSize:     writebuffersize,
+		WriteChannelSize:    writechannlesize,
+		ReadChannelSize:     readchannelsize,
+		IdleTime:            idletime,
+		RequestTimeout:      DEFAULT_REQUEST_TIMEOUT,
+		RequestHolder:       rh,
+		TW:                  tw,
+		Network:             DEFAULT_NETWORK,
+		FrameFormat:         DefaultFrameFormat,
+		Compress:            COMPRESS_NONE,
+		CompressThreshold:   DEFAULT_COMPRESS_THRESHOLD,
+		MaxInboundFrame:     MAX_PACKET_BYTES,
+		MaxOutboundFrame:    MAX_PACKET_BYTES,
+		MaxMissedHeartbeats: DEFAULT_MAX_MISSED_HEARTBEATS,
+		StreamChunkSize:     DEFAULT_STREAM_CHUNK_SIZE,
+		MaxStreamBytes:      DEFAULT_MAX_STREAM_BYTES,
+		MaxStreams:          DEFAULT_MAX_STREAMS,
+		StreamIdleTimeout:   DEFAULT_STREAM_IDLE_TIMEOUT,
+		cancel:              cancel,
 	}
 	return rc
 }
 
+//监听和连接使用的网络
+func (self *TConfig) network() string {
+	if len(self.Network) > 0 {
+		return self.Network
+	}
+	return DEFAULT_NETWORK
+}
+
 type ReqHolder struct {
 	opaque   uint32
 	tw       *TimerWheel
@@ -141,10 +250,56 @@ func (self *ReqHolder) Detach(opaque uint32, obj interface{}) {
 
 	future := self.holder.Remove(opaque)
 	if nil != future {
+		self.cancelTimer(future.(*Future))
 		future.(*Future).SetResponse(obj)
 	}
 }
 
+//以错误结束等待的Future
+func (self *ReqHolder) DetachError(opaque uint32, err error) {
+	future := self.holder.Remove(opaque)
+	if nil != future {
+		self.cancelTimer(future.(*Future))
+		future.(*Future).Error(err)
+	}
+}
+
+//移除等待的future,不设置响应
+func (self *ReqHolder) Remove(opaque uint32) {
+	self.holder.Remove(opaque)
+}
+
+//异步的future不需要等待超时chan,由定时器直接设置超时错误
+func (self *ReqHolder) AttachAsync(opaque uint32, future *Future) {
+	self.holder.Put(opaque, future, 0)
+	if future.timeout <= 0 {
+		return
+	}
+	tid, _ := self.tw.AddTimer(future.timeout, func(tid uint32, t time.Time) {
+		//已经有响应的话移除不到,Error也不会生效
+		self.holder.Remove(opaque)
+		if nil != future.ctx.Err() {
+			future.Error(future.typedError(ErrKindBroken, ERR_CONNECTION_BROKEN))
+		} else {
+			future.Error(future.typedError(ErrKindTimeout, ERR_TIMEOUT))
+		}
+	}, nil)
+	atomic.StoreUint32(&future.timerId, tid)
+	//设置定时器之前响应已经到达
+	select {
+	case <-future.ch:
+		self.cancelTimer(future)
+	default:
+	}
+}
+
+//响应到达之后取消异步future的超时定时器
+func (self *ReqHolder) cancelTimer(future *Future) {
+	if tid := atomic.SwapUint32(&future.timerId, 0); tid > 0 {
+		self.tw.CancelTimer(tid)
+	}
+}
+
 func (self *ReqHolder) Attach(opaque uint32, future *Future) chan time.Time {
 	return self.holder.Put(opaque, future, future.timeout)
 }
@@ -1,6 +1,7 @@
 package turbo
 
 import (
+	"crypto/tls"
 	"errors"
 	"net"
 	"time"
@@ -8,40 +9,80 @@ import (
 
 var CONN_ERROR error = errors.New("STOP LISTENING")
 
+//accept临时错误的重试间隔
+const (
+	MIN_ACCEPT_BACKOFF = 5 * time.Millisecond
+	MAX_ACCEPT_BACKOFF = 1 * time.Second
+)
+
 //远程的listener
+//tcp或者unix socket
+//stop关闭之后再关闭listener,阻塞的Accept返回CONN_ERROR
 type StoppedListener struct {
-	*net.TCPListener
+	net.Listener
 	stop      chan bool
-	conn      chan *net.TCPConn
 	keepalive time.Duration
+	tlsConfig *tls.Config //不为空则使用tls包装连接
 }
 
 //accept
-func (self *StoppedListener) Accept() (*net.TCPConn, error) {
-	for {
-		var conn *net.TCPConn
-		var err error
-		go func() {
-			c, e := self.AcceptTCP()
-			if e != nil {
-				err = e
-			}
-			self.conn <- c
-		}()
-		select {
-		case <-self.stop:
+//返回的是原始的连接,tls的连接需要通过wrap包装,连接数限制等检查在握手之前进行
+func (self *StoppedListener) Accept() (net.Conn, error) {
+	conn, err := self.Listener.Accept()
+	if nil != err {
+		if self.stopped() {
 			return nil, CONN_ERROR
-		case conn = <-self.conn:
-			//do nothing
 		}
+		return nil, err
+	}
+	//stop之后accept到的连接不再处理
+	if self.stopped() {
+		conn.Close()
+		return nil, CONN_ERROR
+	}
 
-		if nil == err {
-			conn.SetKeepAlive(true)
-			conn.SetKeepAlivePeriod(self.keepalive)
-		} else {
-			return nil, err
-		}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(self.keepalive)
+		//tls包装之后就拿不到tcp连接了，这里先禁用nagle
+		tcpConn.SetNoDelay(true)
+	}
+
+	return conn, nil
+}
 
-		return conn, err
+//使用tls包装accept的连接,没有配置tls的原样返回
+func (self *StoppedListener) wrap(conn net.Conn) net.Conn {
+	if nil != self.tlsConfig {
+		//握手在第一次读写的时候进行，不阻塞accept
+		return tls.Server(conn, self.tlsConfig)
+	}
+	return conn
+}
+
+func (self *StoppedListener) stopped() bool {
+	select {
+	case <-self.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+//是否是可以重试的accept错误,例如EMFILE、ECONNABORTED
+func isTemporary(err error) bool {
+	var ne interface{ Temporary() bool }
+	return errors.As(err, &ne) && ne.Temporary()
+}
+
+//下一次重试的间隔,指数增长到MAX_ACCEPT_BACKOFF
+func acceptBackoff(delay time.Duration) time.Duration {
+	if delay <= 0 {
+		return MIN_ACCEPT_BACKOFF
+	}
+	delay *= 2
+	if delay > MAX_ACCEPT_BACKOFF {
+		delay = MAX_ACCEPT_BACKOFF
 	}
+	return delay
 }
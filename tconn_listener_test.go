@@ -0,0 +1,116 @@
+package turbo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStoppedListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	stop := make(chan bool)
+	sl := &StoppedListener{l, stop, time.Minute, nil}
+	result := make(chan error, 1)
+	go func() {
+		_, err := sl.Accept()
+		result <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	l.Close()
+	select {
+	case err := <-result:
+		if err != CONN_ERROR {
+			t.Fatalf("stopped listener should return CONN_ERROR %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked accept should return after close")
+	}
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	delay := time.Duration(0)
+	expect := []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond}
+	for _, e := range expect {
+		if delay = acceptBackoff(delay); delay != e {
+			t.Fatalf("backoff %s, want %s", delay, e)
+		}
+	}
+	if acceptBackoff(MAX_ACCEPT_BACKOFF) != MAX_ACCEPT_BACKOFF {
+		t.Fatal("backoff should not exceed MAX_ACCEPT_BACKOFF")
+	}
+	if !isTemporary(syscall.EMFILE) || isTemporary(errors.New("permanent")) {
+		t.Fatal("EMFILE should be temporary")
+	}
+}
+
+//先返回errs中的错误再accept
+type flakyListener struct {
+	*pipeListener
+	errs chan error
+}
+
+func (self *flakyListener) Accept() (net.Conn, error) {
+	select {
+	case err := <-self.errs:
+		return nil, err
+	default:
+	}
+	return self.pipeListener.Accept()
+}
+
+func TestServeAcceptErrors(t *testing.T) {
+	l := &flakyListener{
+		pipeListener: &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})},
+		errs:         make(chan error, 3)}
+	for i := 0; i < 3; i++ {
+		l.errs <- syscall.EMFILE
+	}
+	config := NewTConfig("turbo-server:flaky", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100)
+	server := NewTServer("", config, delayEcho(0))
+	start := time.Now()
+	if err := server.Serve(l); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	//临时错误退避之后继续accept
+	options := dialOptions("turbo-client:flaky")
+	client := NewTClient(context.Background(), l.dial(), func() ICodec {
+		return LengthBytesCodec{MaxFrameLength: MAX_PACKET_BYTES}
+	}, options.Handler, options.Config)
+	client.Start()
+	defer client.Shutdown()
+	echo(t, client)
+	if cost := time.Since(start); cost < 35*time.Millisecond {
+		t.Fatalf("accept should back off %s", cost)
+	}
+	if n := config.FlowStat.Stat().AcceptErrors; n != 3 {
+		t.Fatalf("accept errors %d, want 3", n)
+	}
+
+	//不可恢复的错误停止accept
+	l.errs <- errors.New("permanent")
+	done := make(chan error, 1)
+	go func() {
+		done <- server.serve(&StoppedListener{l, make(chan bool), time.Minute, nil})
+	}()
+	select {
+	case err := <-done:
+		if nil == err || err.Error() != "permanent" {
+			t.Fatalf("serve should stop with the permanent error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("serve should stop")
+	}
+	if n := config.FlowStat.AcceptErrors.Count(); n != 4 {
+		t.Fatalf("accept errors %d, want 4", n)
+	}
+}
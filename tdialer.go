@@ -0,0 +1,163 @@
+package turbo
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+var ERR_INVALID_DIAL_OPTIONS = errors.New("DIAL OPTIONS NEED CONFIG AND HANDLER")
+
+//没有通过Dial创建的tls连接拿不到原来的tls配置,不能重连
+var ERR_TLS_RECONNECT = errors.New("TLS CONNECTION NOT CREATED BY DIAL CAN NOT RECONNECT")
+
+//默认的连接超时
+const DEFAULT_DIAL_TIMEOUT = 5 * time.Second
+
+//建立连接的参数
+type DialOptions struct {
+	Network   string        //tcp、tcp4、tcp6,默认使用Config.Network;unix:前缀的地址使用unix socket
+	Timeout   time.Duration //连接超时,包含tls握手,默认DEFAULT_DIAL_TIMEOUT
+	LocalAddr string        //绑定的本地地址host:port,为空则由系统分配
+	KeepAlive time.Duration //tcp keepalive的间隔,0使用系统默认,负数关闭
+	TLSConfig *tls.Config   //不为空则使用tls,没有设置ServerName则使用地址中的host
+	//连接建立之前设置socket参数,例如SO_REUSEADDR、SO_MARK
+	Control func(network, address string, c syscall.RawConn) error
+	Codec   func() ICodec //默认LengthBytesCodec
+	Handler THandler      //必须
+	Config  *TConfig      //必须
+	//连接启动之后的握手,例如上传连接元数据,返回错误则关闭连接
+	Handshake func(ctx context.Context, c *TClient) error
+}
+
+func (self *DialOptions) timeout() time.Duration {
+	if self.Timeout > 0 {
+		return self.Timeout
+	}
+	return DEFAULT_DIAL_TIMEOUT
+}
+
+//地址对应的网络类型
+func (self *DialOptions) network(addr string) (string, string) {
+	if sockPath, ok := unixSockPath(addr); ok {
+		return "unix", sockPath
+	}
+	if len(self.Network) > 0 {
+		return self.Network, addr
+	}
+	if nil != self.Config {
+		return self.Config.network(), addr
+	}
+	return DEFAULT_NETWORK, addr
+}
+
+//建立连接并启动TClient
+//	client, err := turbo.Dial(ctx, "localhost:28888", turbo.DialOptions{
+//		Config:  config,
+//		Handler: onMessage})
+//ctx只用于控制连接和握手的超时,不影响连接建立之后的生命周期
+//连接断开之后ClientManager的重连使用相同的地址和参数
+func Dial(ctx context.Context, addr string, options DialOptions) (*TClient, error) {
+	if nil == options.Config || nil == options.Handler {
+		return nil, ERR_INVALID_DIAL_OPTIONS
+	}
+	codec := options.Codec
+	if nil == codec {
+		codec = func() ICodec {
+			return LengthBytesCodec{MaxFrameLength: MAX_PACKET_BYTES}
+		}
+	}
+
+	client := NewTClient(context.Background(), nil, codec, options.Handler, options.Config)
+	client.dialAddr = addr
+	client.dialOptions = &options
+	if err := client.connect(ctx); nil != err {
+		client.closeFunc()
+		return nil, err
+	}
+	return client, nil
+}
+
+//按照Dial的参数建立连接并启动,重连的时候复用
+func (self *TClient) connect(ctx context.Context) error {
+	if nil == self.dialOptions {
+		//tls连接按照普通的tcp重连会被对端拒绝,需要使用Dial并设置TLSConfig
+		if _, ok := self.TLSConnectionState(); ok {
+			log.Errorf("TClient|connect|FAIL|%s|%v", self.remoteAddr, ERR_TLS_RECONNECT)
+			return ERR_TLS_RECONNECT
+		}
+		//没有通过Dial创建的连接按照当前的地址重连
+		self.dialAddr = self.remoteAddr
+		self.dialOptions = &DialOptions{Config: self.config}
+	}
+
+	conn, err := dialConn(ctx, self.dialAddr, self.dialOptions)
+	if nil != err {
+		return err
+	}
+	self.start(conn)
+
+	if nil != self.dialOptions.Handshake {
+		if err := self.dialOptions.Handshake(ctx, self); nil != err {
+			log.Errorf("TClient|Handshake|FAIL|%s|%v", self.dialAddr, err)
+			self.session().closeWith(err)
+			return err
+		}
+	}
+	return nil
+}
+
+//建立物理连接,tls连接完成握手之后返回
+func dialConn(ctx context.Context, addr string, options *DialOptions) (net.Conn, error) {
+	network, address := options.network(addr)
+	ctx, cancel := context.WithTimeout(ctx, options.timeout())
+	defer cancel()
+
+	dialer := &net.Dialer{
+		KeepAlive: options.KeepAlive,
+		Control:   options.Control}
+	if len(options.LocalAddr) > 0 && network != "unix" {
+		local, err := net.ResolveTCPAddr(network, options.LocalAddr)
+		if nil != err {
+			log.Errorf("Dial|LocalAddr|FAIL|%v|%s", err, options.LocalAddr)
+			return nil, err
+		}
+		dialer.LocalAddr = local
+	}
+
+	conn, err := dialer.DialContext(ctx, network, address)
+	if nil != err {
+		log.Errorf("Dial|FAIL|%v|%s|%s", err, network, address)
+		return nil, err
+	}
+	if nil == options.TLSConfig {
+		return conn, nil
+	}
+
+	//tls包装之后就拿不到tcp连接了，先禁用nagle
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetNoDelay(true)
+	}
+	config := options.TLSConfig
+	if len(config.ServerName) <= 0 {
+		if host, _, err := net.SplitHostPort(address); nil == err {
+			config = config.Clone()
+			config.ServerName = host
+		}
+	}
+	tlsConn := tls.Client(conn, config)
+	if deadline, ok := ctx.Deadline(); ok {
+		tlsConn.SetDeadline(deadline)
+	}
+	if err := tlsConn.Handshake(); nil != err {
+		log.Errorf("Dial|Handshake|FAIL|%v|%s", err, address)
+		tlsConn.Close()
+		return nil, err
+	}
+	//握手完成，取消超时
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
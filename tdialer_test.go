@@ -0,0 +1,177 @@
+package turbo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func dialOptions(name string) DialOptions {
+	return DialOptions{
+		Timeout: time.Second,
+		Handler: func(ctx *TContext) error {
+			ctx.Client.Attach(ctx.Message.Header.Opaque, ctx.Message.Data)
+			return nil
+		},
+		Config: NewTConfig(name, 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100)}
+}
+
+func echo(t *testing.T, client *TClient) {
+	p := NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	resp, err := client.WriteAndGet(*p, time.Second)
+	if nil != err || string(resp.([]byte)) != "echo" {
+		t.Fatalf("unexpected response %v|%v", resp, err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	server := NewTServer("localhost:28909", NewTConfig("dial-server", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100), delayEcho(0))
+	if err := server.ListenAndServer(); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	if _, err := Dial(context.Background(), "localhost:28909", DialOptions{}); err != ERR_INVALID_DIAL_OPTIONS {
+		t.Fatalf("dial without config should fail %v", err)
+	}
+
+	var handshakes int32
+	options := dialOptions("dial-client")
+	options.LocalAddr = "127.0.0.1:0"
+	options.Handshake = func(ctx context.Context, c *TClient) error {
+		atomic.AddInt32(&handshakes, 1)
+		echo(t, c)
+		return nil
+	}
+	client, err := Dial(context.Background(), "localhost:28909", options)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+	if atomic.LoadInt32(&handshakes) != 1 || !strings.HasPrefix(client.LocalAddr(), "127.0.0.1:") {
+		t.Fatalf("unexpected handshake %d|%s", handshakes, client.LocalAddr())
+	}
+	echo(t, client)
+
+	//握手失败关闭连接
+	refused := errors.New("refused")
+	options = dialOptions("dial-refused")
+	options.Handshake = func(ctx context.Context, c *TClient) error {
+		return refused
+	}
+	if _, err := Dial(context.Background(), "localhost:28909", options); err != refused {
+		t.Fatalf("handshake error should be returned %v", err)
+	}
+
+	//连接超时
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Dial(ctx, "localhost:28909", dialOptions("dial-cancel")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled dial should fail %v", err)
+	}
+}
+
+//tls的连接断开之后使用Dial的参数重连
+func TestDialReconnectTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, caFile, _ := genCert(t, dir, "ca", nil, nil, true)
+	_, _, serverCert, serverKey := genCert(t, dir, "server", ca, caKey, false)
+	serverTLS, err := NewServerTLSConfig(serverCert, serverKey, "")
+	if nil != err {
+		t.Fatal(err)
+	}
+	server := NewTServer("localhost:28910", NewTConfig("dial-tls-server", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100), delayEcho(0))
+	if err := server.ListenAndServerTLS(serverTLS); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	options := dialOptions("dial-tls-client")
+	options.TLSConfig, err = NewClientTLSConfig("", caFile, "", "")
+	if nil != err {
+		t.Fatal(err)
+	}
+	client, err := Dial(context.Background(), "localhost:28910", options)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+	echo(t, client)
+
+	manager := NewClientManager(NewReconnectManager(true, 100*time.Millisecond, 3,
+		func(ga *GroupAuth, c *TClient) (bool, error) {
+			return true, nil
+		}))
+	manager.Auth(NewGroupAuth("a", "123"), client)
+
+	//服务端关闭连接之后重连
+	if !waitFor(time.Second, func() bool { return len(server.Clients()) == 1 }) {
+		t.Fatal("server should accept the client")
+	}
+	addr := client.RemoteAddr()
+	server.Clients()[0].Shutdown()
+	if !waitFor(time.Second, client.IsClosed) {
+		t.Fatal("client should be closed")
+	}
+	manager.SubmitReconnect(client)
+	if !waitFor(5*time.Second, func() bool { return !client.IsClosed() }) {
+		t.Fatal("client should reconnect")
+	}
+	if _, ok := client.TLSConnectionState(); !ok {
+		t.Fatal("reconnected client should be tls")
+	}
+	//ClientManager以remoteAddr为key,重连之后保持不变
+	if client.RemoteAddr() != addr || nil == manager.FindTClient(addr) {
+		t.Fatalf("remote addr changed after reconnect %s|%s", addr, client.RemoteAddr())
+	}
+	echo(t, client)
+}
+
+//重连之后清除上一个连接收到的GOAWAY
+func TestReconnectResetGoaway(t *testing.T) {
+	server := NewTServer("localhost:28915", NewTConfig("goaway-server", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100), delayEcho(0))
+	if err := server.ListenAndServer(); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	client, err := Dial(context.Background(), "localhost:28915", dialOptions("goaway-client"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	manager := NewClientManager(NewReconnectManager(true, 100*time.Millisecond, 3,
+		func(ga *GroupAuth, c *TClient) (bool, error) {
+			return true, nil
+		}))
+	manager.Auth(NewGroupAuth("a", "123"), client)
+
+	if !waitFor(time.Second, func() bool { return len(server.Clients()) == 1 }) {
+		t.Fatal("server should accept the client")
+	}
+	server.Clients()[0].goAway()
+	if !waitFor(time.Second, client.IsGoaway) {
+		t.Fatal("client should receive GOAWAY")
+	}
+	server.Clients()[0].Shutdown()
+	if !waitFor(time.Second, client.IsClosed) {
+		t.Fatal("client should be closed")
+	}
+	manager.SubmitReconnect(client)
+	if !waitFor(5*time.Second, func() bool { return !client.IsClosed() }) {
+		t.Fatal("client should reconnect")
+	}
+	if client.IsGoaway() {
+		t.Fatal("reconnected client should clear GOAWAY")
+	}
+	found := manager.FindTClients([]string{"a"}, func(groupId string, rc *TClient) bool { return false })
+	if len(found["a"]) != 1 {
+		t.Fatalf("reconnected client should be selectable %v", found)
+	}
+	echo(t, client)
+}
@@ -0,0 +1,176 @@
+package turbo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//写队列满了
+var ERR_WRITE_CHANNEL_FULL = errors.New("WRITE CHANNEL FULL")
+
+//对端返回了错误响应
+var ERR_REMOTE = errors.New("REMOTE ERROR")
+
+//连接关闭的原因,对端关闭或者读写失败时为ErrKindBroken的TurboError
+var (
+	ERR_CLOSED_LOCAL     = errors.New("CLOSED BY LOCAL")    //本地调用Shutdown关闭
+	ERR_HEARTBEAT_MISSED = errors.New("HEARTBEAT MISSED")   //连续多次心跳没有响应
+	ERR_IDLE_TIMEOUT     = errors.New("IDLE TIMEOUT")       //超过MaxIdleTime没有收发
+	ERR_CONN_EXPIRED     = errors.New("CONNECTION EXPIRED") //超过MaxConnAge
+)
+
+//错误的分类
+type ErrorKind uint8
+
+const (
+	ErrKindUnknown      ErrorKind = iota
+	ErrKindTimeout                //等待响应超时
+	ErrKindBroken                 //连接断开
+	ErrKindBackpressure           //写队列或者分发队列满了
+	ErrKindTooLarge               //包体过大
+	ErrKindCodec                  //序列化、反序列化失败
+	ErrKindCancelled              //上下文结束、任务取消
+	ErrKindRemote                 //对端返回的错误响应
+)
+
+func (self ErrorKind) String() string {
+	switch self {
+	case ErrKindTimeout:
+		return "TIMEOUT"
+	case ErrKindBroken:
+		return "BROKEN"
+	case ErrKindBackpressure:
+		return "BACKPRESSURE"
+	case ErrKindTooLarge:
+		return "TOO_LARGE"
+	case ErrKindCodec:
+		return "CODEC"
+	case ErrKindCancelled:
+		return "CANCELLED"
+	case ErrKindRemote:
+		return "REMOTE"
+	}
+	return "UNKNOWN"
+}
+
+//网络层的错误
+//Err为原始的错误,errors.Is/errors.As只匹配Err,按照分类判断使用ErrorKindOf
+type TurboError struct {
+	Kind       ErrorKind
+	RemoteAddr string
+	Opaque     uint32
+	CmdType    uint8
+	Err        error
+}
+
+func newTurboError(kind ErrorKind, err error, remoteAddr string, opaque uint32, cmdType uint8) *TurboError {
+	return &TurboError{
+		Kind:       kind,
+		RemoteAddr: remoteAddr,
+		Opaque:     opaque,
+		CmdType:    cmdType,
+		Err:        err}
+}
+
+func (self *TurboError) Error() string {
+	return fmt.Sprintf("%s|%s|opaque:%d|cmd:%d|%v", self.Kind, self.RemoteAddr, self.Opaque, self.CmdType, self.Err)
+}
+
+func (self *TurboError) Unwrap() error {
+	return self.Err
+}
+
+//是否是超时
+func (self *TurboError) Timeout() bool {
+	return self.Kind == ErrKindTimeout
+}
+
+//获取错误的分类,非TurboError按照哨兵错误判断
+func ErrorKindOf(err error) ErrorKind {
+	if nil == err {
+		return ErrKindUnknown
+	}
+	var terr *TurboError
+	if errors.As(err, &terr) {
+		return terr.Kind
+	}
+	switch err {
+	case ERR_TIMEOUT, ERR_STREAM_IDLE:
+		return ErrKindTimeout
+	case ERR_CONNECTION_BROKEN:
+		return ErrKindBroken
+	case ERR_WRITE_CHANNEL_FULL, ERR_QUEUE_TIMEOUT, ERR_OVER_FLOW, ERR_TOO_MANY_STREAMS, ERR_STREAM_OVERFLOW:
+		return ErrKindBackpressure
+	case ERR_TOO_LARGE_PACKET:
+		return ErrKindTooLarge
+	case ERR_MARSHAL_PACKET, ERR_INVALID_PAYLOAD:
+		return ErrKindCodec
+	case ERR_QUEUE_CONTEXT_DONE, ErrFutureTaskCancelled:
+		return ErrKindCancelled
+	case ERR_REMOTE:
+		return ErrKindRemote
+	}
+	return ErrKindUnknown
+}
+
+//错误响应的状态码
+type ErrorCode uint16
+
+const (
+	ERR_CODE_INTERNAL    ErrorCode = iota + 1 //对端内部错误
+	ERR_CODE_TOO_LARGE                        //包体超过对端的限制
+	ERR_CODE_CODEC                            //对端解析失败
+	ERR_CODE_UNKNOWN_CMD                      //对端没有对应CmdType的处理器
+	ERR_CODE_BUSY                             //对端连接数、accept速率或者接收的大包超过限制
+)
+
+//错误响应包,opaque为出错的请求
+func NewErrorPacket(opaque uint32, code ErrorCode, message string) *Packet {
+	data := make([]byte, 2+len(message))
+	binary.BigEndian.PutUint16(data, uint16(code))
+	copy(data[2:], message)
+	p := NewRespPacket(opaque, CMD_ERROR, data)
+	p.Header.SetResponse(true)
+	return p
+}
+
+//对端返回的错误,包装在ErrKindRemote的TurboError中
+//可以通过errors.As获取状态码
+type RemoteError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (self *RemoteError) Error() string {
+	return fmt.Sprintf("code:%d|%s", self.Code, self.Message)
+}
+
+//所有对端返回的错误都匹配ERR_REMOTE
+func (self *RemoteError) Is(target error) bool {
+	return target == ERR_REMOTE
+}
+
+//解析错误响应包
+func ParseErrorPacket(p *Packet) (ErrorCode, string, error) {
+	if p.Header.CmdType != CMD_ERROR || len(p.Data) < 2 {
+		return 0, "", fmt.Errorf("%w|cmd:%d|bodyLen:%d", ERR_INVALID_FRAME, p.Header.CmdType, len(p.Data))
+	}
+	return ErrorCode(binary.BigEndian.Uint16(p.Data)), string(p.Data[2:]), nil
+}
+
+//本地的错误对应的状态码
+func errorCodeOf(err error) ErrorCode {
+	if errors.Is(err, ERR_UNKNOWN_CMD) {
+		return ERR_CODE_UNKNOWN_CMD
+	}
+	switch ErrorKindOf(err) {
+	case ErrKindTooLarge:
+		return ERR_CODE_TOO_LARGE
+	case ErrKindCodec:
+		return ERR_CODE_CODEC
+	case ErrKindBackpressure:
+		return ERR_CODE_BUSY
+	}
+	return ERR_CODE_INTERNAL
+}
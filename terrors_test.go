@@ -0,0 +1,175 @@
+package turbo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTurboErrorIs(t *testing.T) {
+	cause := errors.New("EOF")
+	err := newTurboError(ErrKindBroken, cause, "localhost:28888", 1, 2)
+	if !errors.Is(err, cause) {
+		t.Fatal("broken error should match the cause")
+	}
+	//只匹配原始的错误,不匹配同一分类的其他错误
+	if errors.Is(err, ERR_CONNECTION_BROKEN) || errors.Is(err, ERR_TIMEOUT) {
+		t.Fatal("broken error should only match the cause")
+	}
+	if errors.Is(errQueueTimeout(), ERR_WRITE_CHANNEL_FULL) ||
+		errors.Is(newTurboError(ErrKindCodec, ERR_INVALID_PAYLOAD, "", 0, 0), ERR_MARSHAL_PACKET) {
+		t.Fatal("errors of the same kind should not match each other")
+	}
+
+	var terr *TurboError
+	if !errors.As(error(err), &terr) || terr.RemoteAddr != "localhost:28888" ||
+		terr.Opaque != 1 || terr.CmdType != 2 {
+		t.Fatalf("unexpected error %v", terr)
+	}
+
+	if ErrorKindOf(ERR_TIMEOUT) != ErrKindTimeout ||
+		ErrorKindOf(err) != ErrKindBroken ||
+		ErrorKindOf(errors.New("other")) != ErrKindUnknown {
+		t.Fatal("unexpected error kind")
+	}
+}
+
+func TestWriteChannelFull(t *testing.T) {
+	config := NewTConfig(
+		"turbo-client:full",
+		100, 16*1024,
+		16*1024, 1, 1,
+		10*time.Second,
+		1000)
+	//不启动写协程,写队列只能放一个包
+	client := NewTClient(context.Background(), nil, nil, func(ctx *TContext) error {
+		return nil
+	}, config)
+	client.remoteAddr = "localhost:28888"
+
+	p := NewPacket(1, nil)
+	if err := client.Write(*p); nil != err {
+		t.Fatal(err)
+	}
+	p = NewPacket(2, nil)
+	p.Header.Opaque = 10
+	err := client.Write(*p)
+	if !errors.Is(err, ERR_WRITE_CHANNEL_FULL) {
+		t.Fatalf("expect channel full %v", err)
+	}
+	var terr *TurboError
+	if !errors.As(err, &terr) || terr.Kind != ErrKindBackpressure ||
+		terr.Opaque != 10 || terr.CmdType != 2 || terr.RemoteAddr != "localhost:28888" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestFutureRemoteAddr(t *testing.T) {
+	config := NewTConfig(
+		"turbo-client:future",
+		100, 16*1024,
+		16*1024, 10, 10,
+		10*time.Second,
+		1000)
+	//不启动写协程,请求一直等到超时
+	client := NewTClient(context.Background(), nil, nil, func(ctx *TContext) error {
+		return nil
+	}, config)
+	client.localAddr = "localhost:50000"
+	client.remoteAddr = "localhost:28888"
+
+	p := NewPacket(2, nil)
+	_, err := client.WriteAndGet(*p, 50*time.Millisecond)
+	var terr *TurboError
+	if !errors.As(err, &terr) || terr.Kind != ErrKindTimeout ||
+		terr.CmdType != 2 || terr.RemoteAddr != "localhost:28888" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestErrorPacket(t *testing.T) {
+	p := NewErrorPacket(10, ERR_CODE_TOO_LARGE, "too large")
+	code, message, err := ParseErrorPacket(p)
+	if nil != err || code != ERR_CODE_TOO_LARGE || message != "too large" || p.Header.Opaque != 10 {
+		t.Fatalf("unexpected error packet %d|%s|%v", code, message, err)
+	}
+	if _, _, err := ParseErrorPacket(NewPacket(1, nil)); !errors.Is(err, ERR_INVALID_FRAME) {
+		t.Fatalf("expect invalid frame %v", err)
+	}
+}
+
+func TestMaxFrame(t *testing.T) {
+	if frameLimit(0, nil) != MAX_PACKET_BYTES ||
+		frameLimit(4096, LengthBytesCodec{MaxFrameLength: 1024}) != 1024 ||
+		frameLimit(512, LengthBytesCodec{MaxFrameLength: 1024}) != 512 ||
+		frameLimit(4096, LengthBytesCodec{}) != 4096 {
+		t.Fatal("unexpected frame limit")
+	}
+
+	_, client := newTestPair(t, "localhost:28898", delayEcho(0), func(config *TConfig) {
+		config.MaxInboundFrame = 1024
+	})
+
+	//超过对端的限制,返回对端的错误,连接继续可用
+	p := NewPacket(1, nil)
+	p.PayLoad = make([]byte, 2048)
+	_, err := client.WriteAndGet(*p, 5*time.Second)
+	var rerr *RemoteError
+	if !errors.As(err, &rerr) || rerr.Code != ERR_CODE_TOO_LARGE {
+		t.Fatalf("expect remote too large %v", err)
+	}
+	p = NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	resp, err := client.WriteAndGet(*p, time.Second)
+	if nil != err || string(resp.([]byte)) != "echo" || client.IsClosed() {
+		t.Fatalf("unexpected response %v|%v", resp, err)
+	}
+
+	//超过本地的发送限制
+	client.s.maxOutbound = 1024
+	p = NewPacket(1, nil)
+	p.PayLoad = make([]byte, 2048)
+	_, err = client.WriteAndGet(*p, time.Second)
+	if !errors.Is(err, ERR_TOO_LARGE_PACKET) {
+		t.Fatalf("expect too large %v", err)
+	}
+}
+
+func TestRemoteError(t *testing.T) {
+	_, client := newTestPair(t, "localhost:28900", func(ctx *TContext) error {
+		if nil != ctx.Err {
+			return nil
+		}
+		return ctx.ReplyError(errors.New("boom"))
+	})
+
+	start := time.Now()
+	p := NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	_, err := client.WriteAndGet(*p, 5*time.Second)
+	var rerr *RemoteError
+	if !errors.Is(err, ERR_REMOTE) || ErrorKindOf(err) != ErrKindRemote ||
+		!errors.As(err, &rerr) || rerr.Code != ERR_CODE_INTERNAL || rerr.Message != "boom" {
+		t.Fatalf("expect remote error %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("remote error should return immediately %s", time.Since(start))
+	}
+
+	//异步的请求
+	errs := make(chan error, 1)
+	p = NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	client.WriteAsync(p, 5*time.Second, func(resp interface{}, err error) {
+		errs <- err
+	})
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ERR_REMOTE) {
+			t.Fatalf("expect remote error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("callback not fired")
+	}
+}
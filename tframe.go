@@ -0,0 +1,192 @@
+package turbo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ERR_INVALID_FRAME = errors.New("INVALID FRAME")
+
+//Extension不足8B,无法携带压缩、分片、响应标记
+var ERR_NARROW_EXTENSION = errors.New("EXTENSION TOO NARROW FOR TURBO FLAGS")
+
+//帧格式,决定包头在网络上的布局
+//通过TConfig.FrameFormat为每个连接选择
+type FrameFormat interface {
+	//包头的字节数
+	HeaderLen() int
+	//把包头追加到buff之后
+	AppendHeader(buff []byte, header PacketHeader, bodyLen int32) ([]byte, error)
+	//从buff中解析包头,buff长度为HeaderLen()
+	UnmarshalHeader(buff []byte) (PacketHeader, error)
+}
+
+//按字段宽度描述的帧格式
+//字段顺序固定为 [Length] Opaque CmdType Version [Extension] BodyLen
+//宽度为0的可选字段不写入
+//Extension小于8B时无法携带Extension最高字节中的压缩等标记,不压缩,分片和Reply返回ERR_NARROW_EXTENSION
+type FieldFrameFormat struct {
+	LengthField    bool             //是否有总包长(不包含本身的4B)
+	Order          binary.ByteOrder //字节序
+	OpaqueBytes    int              //2或4
+	VersionBytes   int              //1或2
+	ExtensionBytes int              //0、4或8
+	BodyLenBytes   int              //2或4
+}
+
+//默认的帧格式,没有总包长
+//|Opaque(4B)|CmdType(1B)|Version(2B)|Extension(8B)|BodyLen(4B)|Body|
+var DefaultFrameFormat FrameFormat = FieldFrameFormat{
+	LengthField:    false,
+	Order:          binary.BigEndian,
+	OpaqueBytes:    4,
+	VersionBytes:   2,
+	ExtensionBytes: 8,
+	BodyLenBytes:   4}
+
+//README中定义的带总包长的帧格式,用于和其他语言的实现互通
+//|Length(4B)|Opaque(4B)|CmdType(1B)|Version(2B)|Extension(8B)|BodyLen(4B)|Body|
+var LengthPrefixedFrameFormat FrameFormat = FieldFrameFormat{
+	LengthField:    true,
+	Order:          binary.BigEndian,
+	OpaqueBytes:    4,
+	VersionBytes:   2,
+	ExtensionBytes: 8,
+	BodyLenBytes:   4}
+
+//配置的帧格式,没有配置的使用默认
+func frameFormat(config *TConfig) FrameFormat {
+	if nil == config || nil == config.FrameFormat {
+		return DefaultFrameFormat
+	}
+	return config.FrameFormat
+}
+
+//帧格式能否携带Extension最高字节中的标记,自定义的帧格式由实现方保证
+func carryFlags(frame FrameFormat) bool {
+	ff, ok := frame.(FieldFrameFormat)
+	return !ok || ff.ExtensionBytes >= 8
+}
+
+//帧格式中opaque的掩码,请求的opaque按照宽度回绕
+func opaqueMask(frame FrameFormat) uint32 {
+	ff, ok := frame.(FieldFrameFormat)
+	if !ok || ff.OpaqueBytes >= 4 {
+		return ^uint32(0)
+	}
+	return uint32(maxOfWidth(ff.OpaqueBytes))
+}
+
+func (self FieldFrameFormat) HeaderLen() int {
+	l := self.OpaqueBytes + 1 + self.VersionBytes + self.ExtensionBytes + self.BodyLenBytes
+	if self.LengthField {
+		l += 4
+	}
+	return l
+}
+
+func (self FieldFrameFormat) AppendHeader(buff []byte, header PacketHeader, bodyLen int32) ([]byte, error) {
+	if bodyLen < 0 || uint64(bodyLen) > maxOfWidth(self.BodyLenBytes) {
+		return buff, fmt.Errorf("%w|bodyLen:%d overflow %dB", ERR_INVALID_FRAME, bodyLen, self.BodyLenBytes)
+	}
+	if uint64(header.Opaque) > maxOfWidth(self.OpaqueBytes) {
+		return buff, fmt.Errorf("%w|opaque:%d overflow %dB", ERR_INVALID_FRAME, header.Opaque, self.OpaqueBytes)
+	}
+	if uint64(uint16(header.Version)) > maxOfWidth(self.VersionBytes) {
+		return buff, fmt.Errorf("%w|version:%d overflow %dB", ERR_INVALID_FRAME, header.Version, self.VersionBytes)
+	}
+	//截断会丢掉高位的标记
+	if uint64(header.Extension) > maxOfWidth(self.ExtensionBytes) {
+		return buff, fmt.Errorf("%w|extension:%x overflow %dB", ERR_INVALID_FRAME, uint64(header.Extension), self.ExtensionBytes)
+	}
+
+	if self.LengthField {
+		buff = self.put(buff, 4, uint64(self.HeaderLen()-4)+uint64(bodyLen))
+	}
+	buff = self.put(buff, self.OpaqueBytes, uint64(header.Opaque))
+	buff = append(buff, header.CmdType)
+	buff = self.put(buff, self.VersionBytes, uint64(uint16(header.Version)))
+	buff = self.put(buff, self.ExtensionBytes, uint64(header.Extension))
+	buff = self.put(buff, self.BodyLenBytes, uint64(bodyLen))
+	return buff, nil
+}
+
+func (self FieldFrameFormat) UnmarshalHeader(buff []byte) (PacketHeader, error) {
+	header := PacketHeader{}
+	if len(buff) < self.HeaderLen() {
+		return header, fmt.Errorf("%w|header:%d/%d", ERR_INVALID_FRAME, len(buff), self.HeaderLen())
+	}
+
+	idx := 0
+	var length uint64
+	if self.LengthField {
+		length = self.get(buff[idx:], 4)
+		idx += 4
+	}
+	header.Opaque = uint32(self.get(buff[idx:], self.OpaqueBytes))
+	idx += self.OpaqueBytes
+	header.CmdType = buff[idx]
+	idx++
+	header.Version = int16(self.get(buff[idx:], self.VersionBytes))
+	idx += self.VersionBytes
+	header.Extension = int64(self.get(buff[idx:], self.ExtensionBytes))
+	idx += self.ExtensionBytes
+	bodyLen := self.get(buff[idx:], self.BodyLenBytes)
+	if bodyLen > uint64(MAX_INT32) {
+		return header, fmt.Errorf("%w|bodyLen:%d", ERR_INVALID_FRAME, bodyLen)
+	}
+	header.BodyLen = int32(bodyLen)
+
+	//总包长和包头中的body长度不一致
+	if self.LengthField && length != uint64(self.HeaderLen()-4)+bodyLen {
+		return header, fmt.Errorf("%w|length:%d|bodyLen:%d", ERR_INVALID_FRAME, length, bodyLen)
+	}
+	return header, nil
+}
+
+const MAX_INT32 = 1<<31 - 1
+
+func maxOfWidth(width int) uint64 {
+	if width >= 8 {
+		return ^uint64(0)
+	}
+	return 1<<(uint(width)*8) - 1
+}
+
+//按宽度写入,直接写在buff的尾部避免临时数组逃逸
+func (self FieldFrameFormat) put(buff []byte, width int, v uint64) []byte {
+	if width <= 0 {
+		return buff
+	}
+	if width == 1 {
+		return append(buff, byte(v))
+	}
+	n := len(buff)
+	buff = append(buff, make([]byte, width)...)
+	switch width {
+	case 2:
+		self.Order.PutUint16(buff[n:], uint16(v))
+	case 4:
+		self.Order.PutUint32(buff[n:], uint32(v))
+	default:
+		self.Order.PutUint64(buff[n:], v)
+	}
+	return buff
+}
+
+//按宽度读取
+func (self FieldFrameFormat) get(buff []byte, width int) uint64 {
+	switch width {
+	case 0:
+		return 0
+	case 1:
+		return uint64(buff[0])
+	case 2:
+		return uint64(self.Order.Uint16(buff))
+	case 4:
+		return uint64(self.Order.Uint32(buff))
+	default:
+		return self.Order.Uint64(buff)
+	}
+}
@@ -0,0 +1,167 @@
+package turbo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFrameFormatRoundTrip(t *testing.T) {
+	header := PacketHeader{Opaque: 1024, CmdType: 3, Version: 2, Extension: 77}
+	formats := []FrameFormat{
+		DefaultFrameFormat,
+		LengthPrefixedFrameFormat,
+		FieldFrameFormat{LengthField: true, Order: binary.LittleEndian,
+			OpaqueBytes: 2, VersionBytes: 1, ExtensionBytes: 4, BodyLenBytes: 2},
+	}
+	for _, f := range formats {
+		buff, err := f.AppendHeader(nil, header, 10)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if len(buff) != f.HeaderLen() {
+			t.Fatalf("header len %d/%d", len(buff), f.HeaderLen())
+		}
+		h, err := f.UnmarshalHeader(buff)
+		if nil != err {
+			t.Fatal(err)
+		}
+		header.BodyLen = 10
+		if h != header {
+			t.Fatalf("unexpected header %+v/%+v", h, header)
+		}
+	}
+
+	//默认格式和MarshalHeader保持兼容
+	buff, _ := DefaultFrameFormat.AppendHeader(nil, header, 10)
+	if !bytes.Equal(buff, MarshalHeader(header, 10).Bytes()) {
+		t.Fatal("default frame format should be compatible with MarshalHeader")
+	}
+
+	//文档中的格式: Length = 包头剩余的19B + body
+	buff, _ = LengthPrefixedFrameFormat.AppendHeader(nil, header, 10)
+	if binary.BigEndian.Uint32(buff) != PACKET_HEAD_LEN+10 {
+		t.Fatalf("unexpected length field %d", binary.BigEndian.Uint32(buff))
+	}
+	binary.BigEndian.PutUint32(buff, 1)
+	if _, err := LengthPrefixedFrameFormat.UnmarshalHeader(buff); !errors.Is(err, ERR_INVALID_FRAME) {
+		t.Fatalf("length mismatch should fail %v", err)
+	}
+
+	narrow := FieldFrameFormat{Order: binary.BigEndian, OpaqueBytes: 2, VersionBytes: 2, BodyLenBytes: 2}
+	if _, err := narrow.AppendHeader(nil, header, 1<<16); !errors.Is(err, ERR_INVALID_FRAME) {
+		t.Fatalf("body len overflow should fail %v", err)
+	}
+	narrow.VersionBytes = 1
+	header.Version = 256
+	if _, err := narrow.AppendHeader(nil, header, 0); !errors.Is(err, ERR_INVALID_FRAME) {
+		t.Fatalf("version overflow should fail %v", err)
+	}
+}
+
+//opaque按照帧格式的宽度回绕,跳过0
+func TestOpaqueWrap(t *testing.T) {
+	config := NewTConfig(
+		"turbo-client:opaque",
+		100, 16*1024,
+		16*1024, 10, 10,
+		10*time.Second,
+		1000)
+	config.FrameFormat = FieldFrameFormat{Order: binary.BigEndian, OpaqueBytes: 2, VersionBytes: 2,
+		ExtensionBytes: 8, BodyLenBytes: 4}
+	client := NewTClient(context.Background(), nil, nil, func(ctx *TContext) error {
+		return nil
+	}, config)
+	config.RequestHolder.opaque = 0xFFFE
+	for _, expect := range []uint32{0xFFFF, 1, 2} {
+		p := NewPacket(1, nil)
+		if opaque := client.fillOpaque(p); opaque != expect {
+			t.Fatalf("expect opaque %d but %d", expect, opaque)
+		}
+	}
+}
+
+//Extension不足8B时不能携带turbo的标记
+func TestNarrowExtension(t *testing.T) {
+	narrow := FieldFrameFormat{Order: binary.BigEndian, OpaqueBytes: 4, VersionBytes: 2,
+		ExtensionBytes: 4, BodyLenBytes: 4}
+	header := PacketHeader{Opaque: 1, CmdType: 1}
+	header.SetResponse(true)
+	if _, err := narrow.AppendHeader(nil, header, 0); !errors.Is(err, ERR_INVALID_FRAME) {
+		t.Fatalf("response flag should overflow %v", err)
+	}
+	if carryFlags(narrow) || !carryFlags(DefaultFrameFormat) {
+		t.Fatal("unexpected carry flags")
+	}
+
+	config := NewTConfig(
+		"turbo-client:narrow",
+		100, 16*1024,
+		16*1024, 10, 10,
+		10*time.Second,
+		1000)
+	config.FrameFormat = narrow
+	config.Compress = COMPRESS_ZSTD
+	client := NewTClient(context.Background(), nil, nil, func(ctx *TContext) error {
+		return nil
+	}, config)
+
+	//不压缩
+	p := NewPacket(1, nil)
+	raw := bytes.Repeat([]byte("narrow "), 1000)
+	data, err := client.compress(p, raw)
+	if nil != err || !bytes.Equal(data, raw) || p.Header.Extension != 0 {
+		t.Fatalf("narrow frame should not compress %v|%d|%x", err, len(data), p.Header.Extension)
+	}
+	if err := client.WriteStream(context.Background(), NewPacket(1, nil), bytes.NewReader(raw)); !errors.Is(err, ERR_NARROW_EXTENSION) {
+		t.Fatalf("expect narrow extension %v", err)
+	}
+	ctx := &TContext{Client: client, Message: NewPacket(1, nil)}
+	if err := ctx.Reply("ok"); !errors.Is(err, ERR_NARROW_EXTENSION) {
+		t.Fatalf("expect narrow extension %v", err)
+	}
+}
+
+//两端都使用带总包长的帧格式
+func TestLengthPrefixedFrame(t *testing.T) {
+	serConfig := NewTConfig(
+		"turbo-server:localhost:28894",
+		100, 16*1024,
+		16*1024, 100, 100,
+		10*time.Second,
+		1000)
+	serConfig.FrameFormat = LengthPrefixedFrameFormat
+	server := NewTServer("localhost:28894", serConfig, delayEcho(0))
+	server.ListenAndServer()
+	defer server.Shutdown(context.Background())
+
+	conn, err := dial("localhost:28894")
+	if nil != err {
+		t.Fatal(err)
+	}
+	config := NewTConfig(
+		"turbo-client:localhost:28894",
+		100, 16*1024,
+		16*1024, 100, 100,
+		10*time.Second,
+		1000)
+	config.FrameFormat = LengthPrefixedFrameFormat
+	client := NewTClient(context.Background(), conn, func() ICodec {
+		return LengthBytesCodec{MaxFrameLength: MAX_PACKET_BYTES}
+	}, func(ctx *TContext) error {
+		ctx.Client.Attach(ctx.Message.Header.Opaque, ctx.Message.Data)
+		return nil
+	}, config)
+	client.Start()
+	defer client.Shutdown()
+
+	p := NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	resp, err := client.WriteAndGet(*p, time.Second)
+	if nil != err || string(resp.([]byte)) != "echo" {
+		t.Fatalf("unexpected response %v|%v", resp, err)
+	}
+}
@@ -0,0 +1,238 @@
+//go:build !windows
+// +build !windows
+
+package turbo
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+//热重启的子进程通过该环境变量获取接收监听socket的unix socket路径
+const HANDOFF_ENV = "TURBO_HANDOFF_SOCK"
+
+//一次最多传递的listener数
+const MAX_HANDOFF_LISTENERS = 16
+
+//子进程开始accept之后回复父进程
+const handoffReady byte = 1
+
+//把监听的socket交给子进程,子进程开始accept之后当前server停止accept并优雅关闭
+//1. 启动cmd,环境变量TURBO_HANDOFF_SOCK为传递fd的unix socket
+//2. 子进程调用ServeInherited连接该socket,接收监听的fd并开始accept
+//3. 子进程回复ready之后调用Shutdown,GOAWAY并等待已有的连接处理完成
+//子进程启动失败或者ctx结束之前没有回复ready则返回错误,当前server继续服务,已经启动的子进程由调用方结束
+//	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+//	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+//	server.Handoff(ctx, cmd)
+func (self *TServer) Handoff(ctx context.Context, cmd *exec.Cmd) error {
+	files, err := self.listenerFiles()
+	if nil != err {
+		log.Errorf("TServer|Handoff|ListenerFiles|FAIL|%v", err)
+		return err
+	}
+	defer closeFiles(files)
+
+	sockPath := filepath.Join(os.TempDir(), fmt.Sprintf("turbo-handoff-%d.sock", os.Getpid()))
+	if err := removeStaleSocket(sockPath); nil != err {
+		return err
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: sockPath, Net: "unix"})
+	if nil != err {
+		log.Errorf("TServer|Handoff|ListenUnix|FAIL|%v|%s", err, sockPath)
+		return err
+	}
+	defer l.Close()
+	done := make(chan struct{})
+	defer close(done)
+	closeOnDone(ctx, done, l)
+
+	if nil == cmd.Env {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, HANDOFF_ENV+"="+sockPath)
+	if err := cmd.Start(); nil != err {
+		log.Errorf("TServer|Handoff|Start|FAIL|%v", err)
+		return err
+	}
+
+	conn, err := l.AcceptUnix()
+	if nil != err {
+		log.Errorf("TServer|Handoff|Accept|FAIL|%v|%v", err, ctx.Err())
+		return handoffErr(ctx, err)
+	}
+	defer conn.Close()
+	closeOnDone(ctx, done, conn)
+
+	fds := make([]int, 0, len(files))
+	for _, f := range files {
+		fds = append(fds, int(f.Fd()))
+	}
+	if _, _, err := conn.WriteMsgUnix([]byte{byte(len(fds))}, syscall.UnixRights(fds...), nil); nil != err {
+		log.Errorf("TServer|Handoff|SendFds|FAIL|%v", err)
+		return handoffErr(ctx, err)
+	}
+
+	//等待子进程开始accept
+	ack := make([]byte, 1)
+	if _, err := conn.Read(ack); nil != err || ack[0] != handoffReady {
+		log.Errorf("TServer|Handoff|WaitReady|FAIL|%v|pid:%d", err, cmd.Process.Pid)
+		if nil == err {
+			err = fmt.Errorf("unexpected handoff ack %d", ack[0])
+		}
+		return handoffErr(ctx, err)
+	}
+	log.Infof("TServer|Handoff|SUCC|pid:%d|listeners:%d", cmd.Process.Pid, len(fds))
+
+	//unix socket的文件由子进程继续使用,关闭时不删除
+	self.lock.Lock()
+	for _, listener := range self.listeners {
+		if ul, ok := listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	self.lock.Unlock()
+	return self.Shutdown(ctx)
+}
+
+//ctx结束时关闭,中断阻塞的accept和读写
+func closeOnDone(ctx context.Context, done chan struct{}, c io.Closer) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+}
+
+func handoffErr(ctx context.Context, err error) error {
+	if nil != ctx.Err() {
+		return ctx.Err()
+	}
+	return err
+}
+
+//所有listener的fd
+func (self *TServer) listenerFiles() ([]*os.File, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(self.listeners) > MAX_HANDOFF_LISTENERS {
+		return nil, fmt.Errorf("too many listeners %d", len(self.listeners))
+	}
+	files := make([]*os.File, 0, len(self.listeners))
+	for _, l := range self.listeners {
+		fl, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			closeFiles(files)
+			return nil, fmt.Errorf("%w|%s", ERR_HANDOFF_UNSUPPORTED, l.Addr())
+		}
+		f, err := fl.File()
+		if nil != err {
+			closeFiles(files)
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+//热重启的子进程从父进程接收监听的socket并开始服务,之后通知父进程优雅关闭
+//不是通过Handoff启动的返回false,需要自己监听
+//tlsConfig不为空则使用tls包装连接
+//	if ok, err := server.ServeInherited(nil); !ok && nil == err {
+//		err = server.ListenAndServer()
+//	}
+func (self *TServer) ServeInherited(tlsConfig *tls.Config) (bool, error) {
+	sockPath := os.Getenv(HANDOFF_ENV)
+	if len(sockPath) <= 0 {
+		return false, nil
+	}
+	//子进程再次热重启时使用新的路径
+	os.Unsetenv(HANDOFF_ENV)
+
+	conn, err := net.DialTimeout("unix", sockPath, DEFAULT_DIAL_TIMEOUT)
+	if nil != err {
+		log.Errorf("TServer|ServeInherited|Dial|FAIL|%v|%s", err, sockPath)
+		return true, err
+	}
+	defer conn.Close()
+	uconn := conn.(*net.UnixConn)
+	uconn.SetDeadline(time.Now().Add(DEFAULT_DIAL_TIMEOUT))
+
+	listeners, err := recvListeners(uconn)
+	if nil != err {
+		log.Errorf("TServer|ServeInherited|Recv|FAIL|%v|%s", err, sockPath)
+		return true, err
+	}
+	for _, l := range listeners {
+		self.serveListener(l, tlsConfig)
+	}
+
+	if _, err := uconn.Write([]byte{handoffReady}); nil != err {
+		log.Errorf("TServer|ServeInherited|Ready|FAIL|%v|%s", err, sockPath)
+		return true, err
+	}
+	log.Infof("TServer|ServeInherited|SUCC|listeners:%d", len(listeners))
+	return true, nil
+}
+
+//接收父进程传递的fd
+func recvListeners(conn *net.UnixConn) ([]net.Listener, error) {
+	buff := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(MAX_HANDOFF_LISTENERS*4))
+	_, oobn, _, _, err := conn.ReadMsgUnix(buff, oob)
+	if nil != err {
+		return nil, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if nil != err {
+		return nil, err
+	}
+	fds := make([]int, 0, int(buff[0]))
+	for i := range msgs {
+		rights, err := syscall.ParseUnixRights(&msgs[i])
+		if nil != err {
+			return nil, err
+		}
+		fds = append(fds, rights...)
+	}
+
+	listeners := make([]net.Listener, 0, len(fds))
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "handoff")
+		l, err := net.FileListener(f)
+		f.Close()
+		if nil != err {
+			for _, l := range listeners {
+				l.Close()
+			}
+			for _, fd := range fds[i+1:] {
+				syscall.Close(fd)
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) != int(buff[0]) {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, fmt.Errorf("expect %d listeners but got %d", buff[0], len(listeners))
+	}
+	return listeners, nil
+}
@@ -0,0 +1,93 @@
+//go:build !windows
+// +build !windows
+
+package turbo
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//回复当前进程的pid
+func replyPid(ctx *TContext) error {
+	if nil != ctx.Err {
+		return nil
+	}
+	return ctx.Reply([]byte(strconv.Itoa(os.Getpid())))
+}
+
+func pidOf(t *testing.T, client *TClient) int {
+	p := NewPacket(1, nil)
+	p.PayLoad = []byte("pid")
+	resp, err := client.WriteAndGet(*p, time.Second)
+	if nil != err {
+		t.Fatal(err)
+	}
+	pid, _ := strconv.Atoi(string(resp.([]byte)))
+	return pid
+}
+
+//热重启的子进程,由TestHandoff启动
+func TestHandoffChild(t *testing.T) {
+	if len(os.Getenv(HANDOFF_ENV)) <= 0 {
+		t.Skip("not a handoff child")
+	}
+	server := NewTServer("", NewTConfig("handoff-child", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100), replyPid)
+	if ok, err := server.ServeInherited(nil); !ok || nil != err {
+		t.Fatalf("serve inherited %v|%v", ok, err)
+	}
+	if len(os.Getenv(HANDOFF_ENV)) > 0 {
+		t.Fatal("handoff env should be cleared")
+	}
+	//由父进程结束
+	time.Sleep(10 * time.Second)
+}
+
+func TestHandoff(t *testing.T) {
+	server := NewTServer("localhost:28913", NewTConfig("handoff-parent", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100), replyPid)
+	if ok, err := server.ServeInherited(nil); ok || nil != err {
+		t.Fatalf("should not be inherited %v|%v", ok, err)
+	}
+	if err := server.ListenAndServer(); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	old, err := Dial(context.Background(), "localhost:28913", dialOptions("handoff-old"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer old.Shutdown()
+	if pid := pidOf(t, old); pid != os.Getpid() {
+		t.Fatalf("unexpected pid %d", pid)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHandoffChild$")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Handoff(ctx, cmd); nil != err {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	//原来的连接GOAWAY之后关闭
+	if !waitFor(time.Second, old.IsGoaway) || !waitFor(time.Second, old.IsClosed) {
+		t.Fatal("old connection should be drained")
+	}
+	//新的连接由子进程处理
+	client, err := Dial(context.Background(), "localhost:28913", dialOptions("handoff-new"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+	if pid := pidOf(t, client); pid != cmd.Process.Pid {
+		t.Fatalf("new connection should be served by child %d|%d", pid, cmd.Process.Pid)
+	}
+}
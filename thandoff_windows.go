@@ -0,0 +1,16 @@
+package turbo
+
+import (
+	"context"
+	"crypto/tls"
+	"os/exec"
+)
+
+//windows不支持通过unix socket传递fd
+func (self *TServer) Handoff(ctx context.Context, cmd *exec.Cmd) error {
+	return ERR_HANDOFF_UNSUPPORTED
+}
+
+func (self *TServer) ServeInherited(tlsConfig *tls.Config) (bool, error) {
+	return false, nil
+}
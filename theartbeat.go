@@ -0,0 +1,83 @@
+package turbo
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+//默认连续3次心跳没有响应则关闭连接
+const DEFAULT_MAX_MISSED_HEARTBEATS = 3
+
+//开启心跳检查
+//每隔IdleTime检查一次,IdleTime内没有读取到任何包则发送ping
+//连续MaxMissedHeartbeats次都没有读取到包则关闭连接,由ClientManager重连
+func (self *TClient) startHeartbeat() {
+	if !self.config.Heartbeat || self.config.IdleTime <= 0 {
+		return
+	}
+	tid := self.config.TW.RepeatedTimer(self.config.IdleTime, func(tid uint32, t time.Time) {
+		self.checkHeartbeat()
+	}, nil)
+	//重连之后Start会再次开启,取消之前的
+	if old := atomic.SwapUint32(&self.heartbeatTid, tid); old > 0 {
+		self.config.TW.CancelTimer(old)
+	}
+	atomic.StoreInt32(&self.missedHeartbeats, 0)
+}
+
+//停止心跳检查
+func (self *TClient) stopHeartbeat() {
+	if tid := atomic.SwapUint32(&self.heartbeatTid, 0); tid > 0 {
+		self.config.TW.CancelTimer(tid)
+	}
+}
+
+func (self *TClient) checkHeartbeat() {
+	if self.IsClosed() {
+		self.stopHeartbeat()
+		return
+	}
+
+	//对端还有数据过来,不需要心跳
+	if time.Since(self.LastReadTime()) < self.config.IdleTime {
+		atomic.StoreInt32(&self.missedHeartbeats, 0)
+		return
+	}
+
+	maxMissed := self.config.MaxMissedHeartbeats
+	if maxMissed <= 0 {
+		maxMissed = DEFAULT_MAX_MISSED_HEARTBEATS
+	}
+	missed := atomic.AddInt32(&self.missedHeartbeats, 1)
+	if int(missed) > maxMissed {
+		log.Warnf("TClient|Heartbeat|%s|MISSED %d|CLOSE SESSION", self.remoteAddr, missed-1)
+		self.stopHeartbeat()
+		self.session().closeWith(ERR_HEARTBEAT_MISSED)
+		return
+	}
+
+	//body为发送时间,对端原样返回
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(time.Now().UnixNano()))
+	if err := self.Write(*NewPacket(CMD_PING, data)); nil != err {
+		log.Errorf("TClient|Heartbeat|Ping|FAIL|%s|%v", self.remoteAddr, err)
+	}
+}
+
+//收到心跳
+func (self *TClient) onHeartbeat(msg Packet) {
+	switch msg.Header.CmdType {
+	case CMD_PING:
+		//原样回复
+		data := append([]byte(nil), msg.Data...)
+		if err := self.Write(*NewRespPacket(msg.Header.Opaque, CMD_PONG, data)); nil != err {
+			log.Errorf("TClient|Heartbeat|Pong|FAIL|%s|%v", self.remoteAddr, err)
+		}
+	case CMD_PONG:
+		atomic.StoreInt32(&self.missedHeartbeats, 0)
+		if len(msg.Data) >= 8 {
+			self.updateHeartBeat(int64(binary.BigEndian.Uint64(msg.Data)))
+		}
+	}
+}
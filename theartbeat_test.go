@@ -0,0 +1,104 @@
+package turbo
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//开启心跳的client
+func heartbeatClient(t *testing.T, hostport string, idle time.Duration, maxMissed int) *TClient {
+	conn, err := dial(hostport)
+	if nil != err {
+		t.Fatal(err)
+	}
+	config := NewTConfig("heartbeat:"+hostport, 10, 16*1024, 16*1024, 100, 100, idle, 100)
+	config.Heartbeat = true
+	config.MaxMissedHeartbeats = maxMissed
+	client := NewTClient(context.Background(), conn, func() ICodec {
+		return LengthBytesCodec{MaxFrameLength: MAX_PACKET_BYTES}
+	}, func(ctx *TContext) error {
+		return nil
+	}, config)
+	client.Start()
+	t.Cleanup(client.Shutdown)
+	return client
+}
+
+func TestHeartbeat(t *testing.T) {
+	server := NewTServer("localhost:28901", NewTConfig("heartbeat-server", 10, 16*1024, 16*1024, 100, 100,
+		10*time.Second, 100), func(ctx *TContext) error {
+		return nil
+	})
+	if err := server.ListenAndServer(); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	//对端自动回复心跳,连接保持
+	client := heartbeatClient(t, "localhost:28901", 200*time.Millisecond, 2)
+	time.Sleep(1500 * time.Millisecond)
+	if heartbeat := atomic.LoadInt64(&client.heartbeat); client.IsClosed() || heartbeat <= 0 {
+		t.Fatalf("connection should be kept alive by heartbeat|closed:%v|heartbeat:%d",
+			client.IsClosed(), heartbeat)
+	}
+}
+
+func TestHeartbeatMissed(t *testing.T) {
+	//只接受连接不回复任何数据
+	l, err := net.Listen("tcp4", "localhost:28902")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if nil != err {
+			return
+		}
+		defer conn.Close()
+		buff := make([]byte, 1024)
+		for {
+			if _, err := conn.Read(buff); nil != err {
+				return
+			}
+		}
+	}()
+
+	client := heartbeatClient(t, "localhost:28902", 100*time.Millisecond, 2)
+	deadline := time.Now().Add(3 * time.Second)
+	for !client.IsClosed() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !client.IsClosed() {
+		t.Fatal("connection should be closed after missed heartbeats")
+	}
+	if atomic.LoadUint32(&client.heartbeatTid) != 0 {
+		t.Fatal("heartbeat timer should be stopped")
+	}
+	if client.CloseReason() != ERR_HEARTBEAT_MISSED {
+		t.Fatalf("unexpected close reason %v", client.CloseReason())
+	}
+}
+
+func TestSessionIdle(t *testing.T) {
+	config := NewTConfig("idle", 10, 16*1024, 16*1024, 100, 100, time.Second, 100)
+	r, w := net.Pipe()
+	defer r.Close()
+	s := NewSession(w, config, nil)
+	defer s.Close()
+	if s.Idle() {
+		t.Fatal("new session should not be idle")
+	}
+	old := time.Now().Add(-2 * time.Second).UnixNano()
+	atomic.StoreInt64(&s.lastRead, old)
+	if s.Idle() {
+		t.Fatal("session with recent write should not be idle")
+	}
+	atomic.StoreInt64(&s.lastWrite, old)
+	if !s.Idle() {
+		t.Fatal("session should be idle")
+	}
+}
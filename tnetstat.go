@@ -8,18 +8,19 @@ import (
 
 //network stat
 type NetworkStat struct {
-	ReadCount   int32 `json:"read_count"`
-	ReadBytes   int32 `json:"read_bytes"`
-	WriteCount  int32 `json:"write_count"`
-	WriteBytes  int32 `json:"write_bytes"`
-	DisPoolSize int32 `json:"dispool_size"`
-	DisPoolCap  int32 `json:"dispool_cap"`
-	Connections int32 `json:"connections"`
+	ReadCount    int32 `json:"read_count"`
+	ReadBytes    int32 `json:"read_bytes"`
+	WriteCount   int32 `json:"write_count"`
+	WriteBytes   int32 `json:"write_bytes"`
+	DisPoolSize  int32 `json:"dispool_size"`
+	DisPoolCap   int32 `json:"dispool_cap"`
+	Connections  int32 `json:"connections"`
+	AcceptErrors int32 `json:"accept_errors"`
 }
 
 func (self NetworkStat) String() string {
-	return fmt.Sprintf("read:%dKB/%d\twrite:%dKB/%d\tgo:%d/%d\tconns:%d", self.ReadBytes/1024, self.ReadCount,
-		self.WriteBytes/1024, self.WriteCount, self.DisPoolSize, self.DisPoolCap, self.Connections)
+	return fmt.Sprintf("read:%dKB/%d\twrite:%dKB/%d\tgo:%d/%d\tconns:%d\taccept_errors:%d", self.ReadBytes/1024, self.ReadCount,
+		self.WriteBytes/1024, self.WriteCount, self.DisPoolSize, self.DisPoolCap, self.Connections, self.AcceptErrors)
 }
 
 type RemotingFlow struct {
@@ -32,6 +33,8 @@ type RemotingFlow struct {
 	WriteFlow      *Flow
 	WriteBytesFlow *Flow
 	Connections    *Flow
+	Rejected       *Flow    //超过连接限制被拒绝的连接
+	AcceptErrors   *Flow    //accept失败的次数
 	Clients        sync.Map //所有的客户端链接
 	pool           *GPool
 }
@@ -47,20 +50,23 @@ func NewRemotingFlow(name string, pool *GPool) *RemotingFlow {
 		DispatcherGo:   &Flow{},
 		WriteFlow:      &Flow{},
 		WriteBytesFlow: &Flow{},
-		Connections:    &Flow{}}
+		Connections:    &Flow{},
+		Rejected:       &Flow{},
+		AcceptErrors:   &Flow{}}
 }
 
 //网络状态
 func (self *RemotingFlow) Stat() NetworkStat {
 	disSize, disCap := self.pool.Monitor()
 	return NetworkStat{
-		ReadCount:   self.ReadFlow.Changes(),
-		ReadBytes:   self.ReadBytesFlow.Changes(),
-		DisPoolSize: int32(disSize),
-		DisPoolCap:  int32(disCap),
-		WriteCount:  self.WriteFlow.Changes(),
-		WriteBytes:  self.WriteBytesFlow.Changes(),
-		Connections: self.Connections.Count()}
+		ReadCount:    self.ReadFlow.Changes(),
+		ReadBytes:    self.ReadBytesFlow.Changes(),
+		DisPoolSize:  int32(disSize),
+		DisPoolCap:   int32(disCap),
+		WriteCount:   self.WriteFlow.Changes(),
+		WriteBytes:   self.WriteBytesFlow.Changes(),
+		Connections:  self.Connections.Count(),
+		AcceptErrors: self.AcceptErrors.Count()}
 }
 
 type Flow struct {
@@ -3,10 +3,52 @@ package turbo
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
 )
 
 type Compress int8
 
+//body的压缩算法
+const (
+	COMPRESS_NONE Compress = iota
+	COMPRESS_GZIP
+	COMPRESS_SNAPPY
+	COMPRESS_ZSTD
+)
+
+//Extension的最高字节保留给turbo使用,业务只使用低56位
+//bit56-58 body的压缩算法
+//bit59    大包的分片
+//bit60    大包的最后一个分片
+//bit61    大包发送中断
+//bit62    响应包
+const (
+	EXT_COMPRESS_SHIFT        = 56
+	EXT_COMPRESS_MASK   int64 = 0x7 << EXT_COMPRESS_SHIFT
+	EXT_CHUNK           int64 = 1 << 59
+	EXT_CHUNK_END       int64 = 1 << 60
+	EXT_CHUNK_ABORT     int64 = 1 << 61
+	EXT_CHUNK_FLAG_MASK int64 = EXT_CHUNK | EXT_CHUNK_END | EXT_CHUNK_ABORT
+	EXT_RESPONSE        int64 = 1 << 62
+)
+
+//保留的控制命令类型,业务层不要使用 CMD_RESERVED_MIN 以上的CmdType
+//不兼容的变更:之前的版本CmdType可以使用全部的0x00-0xFF,
+//现在 CMD_RESERVED_MIN 以上的包作为控制命令由turbo处理,不会再分发给THandler,
+//使用了0xF0-0xFF的业务需要在升级之前迁移到其他的CmdType
+const (
+	CMD_RESERVED_MIN uint8 = 0xF0
+	CMD_PONG         uint8 = 0xFC //心跳响应,body和ping相同
+	CMD_PING         uint8 = 0xFD //心跳,body为发送时间
+	CMD_ERROR        uint8 = 0xFE //错误响应,body为 |Code(2B)|Message|
+	CMD_GOAWAY       uint8 = 0xFF //对端即将关闭，不要再发送新的请求
+)
+
+//是否是turbo内部的控制命令
+func IsControlCmd(cmdType uint8) bool {
+	return cmdType >= CMD_RESERVED_MIN
+}
+
 //packet的包头部分
 type PacketHeader struct {
 	Opaque    uint32 //请求的seqId
@@ -16,45 +58,53 @@ type PacketHeader struct {
 	BodyLen   int32  //body的长度
 }
 
-func MarshalHeader(header PacketHeader, bodyLen int32) *bytes.Buffer {
-	b := make([]byte, 0, PACKET_HEAD_LEN+bodyLen)
-	buff := bytes.NewBuffer(b)
-	//写入包头长度
-	Write(buff, binary.BigEndian, header.Opaque)
-	Write(buff, binary.BigEndian, header.CmdType)
-	Write(buff, binary.BigEndian, header.Version)
-	Write(buff, binary.BigEndian, header.Extension)
-	Write(buff, binary.BigEndian, bodyLen)
-	return buff
+//body的压缩算法
+func (self PacketHeader) Compress() Compress {
+	return Compress((self.Extension & EXT_COMPRESS_MASK) >> EXT_COMPRESS_SHIFT)
 }
 
-func UnmarshalHeader(r *bytes.Reader) (PacketHeader, error) {
-	header := PacketHeader{}
-	err := Read(r, binary.BigEndian, &(header.Opaque))
-	if nil != err {
-		return header, err
-	}
+//设置body的压缩算法
+func (self *PacketHeader) SetCompress(c Compress) {
+	self.Extension = (self.Extension &^ EXT_COMPRESS_MASK) | (int64(c)<<EXT_COMPRESS_SHIFT)&EXT_COMPRESS_MASK
+}
 
-	err = Read(r, binary.BigEndian, &(header.CmdType))
-	if nil != err {
-		return header, err
-	}
+//是否是响应包
+func (self PacketHeader) IsResponse() bool {
+	return self.Extension&EXT_RESPONSE != 0
+}
 
-	err = Read(r, binary.BigEndian, &(header.Version))
-	if nil != err {
-		return header, err
+//标记为响应包,对端收到之后直接交给等待的Future
+func (self *PacketHeader) SetResponse(resp bool) {
+	if resp {
+		self.Extension |= EXT_RESPONSE
+	} else {
+		self.Extension &^= EXT_RESPONSE
 	}
+}
 
-	err = Read(r, binary.BigEndian, &(header.Extension))
-	if nil != err {
-		return header, err
-	}
+func MarshalHeader(header PacketHeader, bodyLen int32) *bytes.Buffer {
+	b := make([]byte, PACKET_HEAD_LEN, PACKET_HEAD_LEN+bodyLen)
+	//直接按照大端写入,不经过反射
+	binary.BigEndian.PutUint32(b[0:], header.Opaque)
+	b[4] = header.CmdType
+	binary.BigEndian.PutUint16(b[5:], uint16(header.Version))
+	binary.BigEndian.PutUint64(b[7:], uint64(header.Extension))
+	binary.BigEndian.PutUint32(b[15:], uint32(bodyLen))
+	return bytes.NewBuffer(b)
+}
 
-	err = Read(r, binary.BigEndian, &(header.BodyLen))
-	if nil != err {
+func UnmarshalHeader(r *bytes.Reader) (PacketHeader, error) {
+	header := PacketHeader{}
+	var b [PACKET_HEAD_LEN]byte
+	if _, err := io.ReadFull(r, b[:]); nil != err {
 		return header, err
 	}
-
+	//直接按照大端解析,不经过反射
+	header.Opaque = binary.BigEndian.Uint32(b[0:])
+	header.CmdType = b[4]
+	header.Version = int16(binary.BigEndian.Uint16(b[5:]))
+	header.Extension = int64(binary.BigEndian.Uint64(b[7:]))
+	header.BodyLen = int32(binary.BigEndian.Uint32(b[15:]))
 	return header, nil
 }
 
@@ -65,6 +115,7 @@ type Packet struct {
 	PayLoad interface{}
 	//packet的回调
 	OnComplete func(err error)
+	buff       *[]byte //Data所在的缓冲池中的缓冲
 }
 
 func NewPacket(cmdtype uint8, data []byte) *Packet {
@@ -77,6 +128,24 @@ func (self *Packet) Reset() {
 	self.Header.Opaque = 0
 }
 
+//写出完成的回调
+func (self *Packet) complete(err error) {
+	if nil != self.OnComplete {
+		self.OnComplete(err)
+	}
+}
+
+//把读取时从缓冲池分配的Data归还
+//调用之后Data以及引用了Data的PayLoad(例如LengthBytesCodec)都不能再使用
+//不调用也不会泄漏,只是由GC回收
+func (self *Packet) Release() {
+	if nil != self.buff {
+		putBuffer(self.buff)
+		self.buff = nil
+		self.Data = nil
+	}
+}
+
 func NewRespPacket(opaque uint32, cmdtype uint8, data []byte) *Packet {
 	p := NewPacket(cmdtype, data)
 	p.Header.Opaque = opaque
@@ -107,3 +176,26 @@ type TContext struct {
 	Message *Packet
 	Err     error //上下文的错误
 }
+
+//回复当前请求,响应包带有响应标记,对端自动交给等待的Future
+//帧格式携带不了响应标记的返回ERR_NARROW_EXTENSION
+func (self *TContext) Reply(payload interface{}) error {
+	if !carryFlags(frameFormat(self.Client.config)) {
+		return ERR_NARROW_EXTENSION
+	}
+	header := self.Message.Header
+	resp := NewRespPacket(header.Opaque, header.CmdType, nil)
+	resp.Header.Version = header.Version
+	resp.Header.SetResponse(true)
+	resp.PayLoad = payload
+	return self.Client.Write(*resp)
+}
+
+//以错误响应回复当前请求
+func (self *TContext) ReplyError(err error) error {
+	if !carryFlags(frameFormat(self.Client.config)) {
+		return ERR_NARROW_EXTENSION
+	}
+	resp := NewErrorPacket(self.Message.Header.Opaque, errorCodeOf(err), err.Error())
+	return self.Client.Write(*resp)
+}
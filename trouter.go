@@ -0,0 +1,142 @@
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime/debug"
+	"time"
+)
+
+var ERR_UNKNOWN_CMD = errors.New("UNKNOWN CMD TYPE")
+
+//中间件,包装下一个处理器
+type Middleware func(next THandler) THandler
+
+//按照CmdType分发的处理器
+//注册和Use需要在启动之前完成,使用router.Dispatch作为TServer或者TClient的THandler
+//	router := turbo.NewRouter().Use(turbo.Recovery())
+//	router.Handle(CMD_LOGIN, onLogin)
+//	server := turbo.NewTServer(hostport, config, router.Dispatch)
+type Router struct {
+	routes      map[uint8][]route
+	middlewares []Middleware
+	chain       THandler //包装了所有中间件的分发
+}
+
+//一个CmdType下按照Version区间匹配的处理器
+type route struct {
+	minVersion int16
+	maxVersion int16
+	handler    THandler
+}
+
+func NewRouter() *Router {
+	router := &Router{routes: make(map[uint8][]route)}
+	router.chain = router.route
+	return router
+}
+
+//注册CmdType的处理器,匹配所有的Version
+func (self *Router) Handle(cmdType uint8, handler THandler) *Router {
+	return self.HandleVersion(cmdType, math.MinInt16, math.MaxInt16, handler)
+}
+
+//注册CmdType在[minVersion,maxVersion]区间的处理器
+//区间重叠时先注册的优先
+func (self *Router) HandleVersion(cmdType uint8, minVersion, maxVersion int16, handler THandler) *Router {
+	self.routes[cmdType] = append(self.routes[cmdType], route{
+		minVersion: minVersion,
+		maxVersion: maxVersion,
+		handler:    handler})
+	return self
+}
+
+//添加中间件,先添加的在最外层
+func (self *Router) Use(middlewares ...Middleware) *Router {
+	self.middlewares = append(self.middlewares, middlewares...)
+	chain := THandler(self.route)
+	for i := len(self.middlewares) - 1; i >= 0; i-- {
+		chain = self.middlewares[i](chain)
+	}
+	self.chain = chain
+	return self
+}
+
+//THandler
+func (self *Router) Dispatch(ctx *TContext) error {
+	return self.chain(ctx)
+}
+
+//查找对应的处理器
+func (self *Router) match(header PacketHeader) THandler {
+	for _, r := range self.routes[header.CmdType] {
+		if header.Version >= r.minVersion && header.Version <= r.maxVersion {
+			return r.handler
+		}
+	}
+	return nil
+}
+
+func (self *Router) route(ctx *TContext) error {
+	header := ctx.Message.Header
+	handler := self.match(header)
+	if nil != handler {
+		return handler(ctx)
+	}
+
+	err := fmt.Errorf("%w|cmd:%d|version:%d", ERR_UNKNOWN_CMD, header.CmdType, header.Version)
+	//本地的错误不用回复对端
+	if nil == ctx.Err && nil != ctx.Client {
+		if werr := ctx.ReplyError(err); nil != werr {
+			log.Errorf("Router|route|ReplyError|FAIL|%s|cmd:%d|%v", remoteAddrOf(ctx), header.CmdType, werr)
+		}
+	}
+	return err
+}
+
+//处理器panic时返回错误并回复对端
+func Recovery() Middleware {
+	return func(next THandler) THandler {
+		return func(ctx *TContext) (err error) {
+			defer func() {
+				if r := recover(); nil != r {
+					log.Errorf("Router|Recovery|%d|%v|%s", ctx.Message.Header.CmdType, r, string(debug.Stack()))
+					err = fmt.Errorf("%v", r)
+					if nil == ctx.Err && nil != ctx.Client {
+						if werr := ctx.ReplyError(err); nil != werr {
+							log.Errorf("Router|Recovery|ReplyError|FAIL|%s|%v", remoteAddrOf(ctx), werr)
+						}
+					}
+				}
+			}()
+			return next(ctx)
+		}
+	}
+}
+
+//记录每个请求的处理耗时
+func Logging() Middleware {
+	return func(next THandler) THandler {
+		return func(ctx *TContext) error {
+			now := time.Now()
+			err := next(ctx)
+			header := ctx.Message.Header
+			if nil != err {
+				log.Errorf("Router|Handle|%s|cmd:%d|version:%d|opaque:%d|%s|FAIL|%v", remoteAddrOf(ctx),
+					header.CmdType, header.Version, header.Opaque, time.Since(now), err)
+			} else {
+				log.Debugf("Router|Handle|%s|cmd:%d|version:%d|opaque:%d|%s", remoteAddrOf(ctx),
+					header.CmdType, header.Version, header.Opaque, time.Since(now))
+			}
+			return err
+		}
+	}
+}
+
+func remoteAddrOf(ctx *TContext) string {
+	if nil == ctx.Client {
+		return ""
+	}
+	return ctx.Client.RemoteAddr()
+}
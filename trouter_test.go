@@ -0,0 +1,84 @@
+package turbo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRouter(t *testing.T) {
+	config := NewTConfig("router", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100)
+	client := NewTClient(context.Background(), nil, nil, nil, config)
+
+	calls := make([]string, 0, 8)
+	trace := func(name string) Middleware {
+		return func(next THandler) THandler {
+			return func(ctx *TContext) error {
+				calls = append(calls, name)
+				return next(ctx)
+			}
+		}
+	}
+	handler := func(name string) THandler {
+		return func(ctx *TContext) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+
+	router := NewRouter().
+		HandleVersion(1, 0, 1, handler("v0-1")).
+		HandleVersion(1, 2, 5, handler("v2-5")).
+		Handle(2, func(ctx *TContext) error {
+			panic("boom")
+		}).
+		Use(trace("outer"), trace("inner"), Recovery(), Logging())
+
+	dispatch := func(cmdType uint8, version int16) error {
+		calls = calls[:0]
+		p := NewRespPacket(10, cmdType, nil)
+		p.Header.Version = version
+		return router.Dispatch(&TContext{Message: p, Client: client})
+	}
+
+	if err := dispatch(1, 1); nil != err || len(calls) != 3 ||
+		calls[0] != "outer" || calls[1] != "inner" || calls[2] != "v0-1" {
+		t.Fatalf("unexpected calls %v|%v", calls, err)
+	}
+	if err := dispatch(1, 3); nil != err || calls[2] != "v2-5" {
+		t.Fatalf("unexpected calls %v|%v", calls, err)
+	}
+
+	//没有匹配的Version和CmdType回复错误响应
+	for _, cmd := range [][2]int{{1, 6}, {3, 0}} {
+		err := dispatch(uint8(cmd[0]), int16(cmd[1]))
+		if !errors.Is(err, ERR_UNKNOWN_CMD) {
+			t.Fatalf("expect unknown cmd %v", err)
+		}
+		p := <-client.wchan
+		code, _, err := ParseErrorPacket(p)
+		if nil != err || code != ERR_CODE_UNKNOWN_CMD || p.Header.Opaque != 10 {
+			t.Fatalf("unexpected error packet %d|%v", code, err)
+		}
+	}
+
+	//panic转换为错误
+	if err := dispatch(2, 0); nil == err {
+		t.Fatal("expect recovered error")
+	}
+	p := <-client.wchan
+	if code, _, _ := ParseErrorPacket(p); code != ERR_CODE_INTERNAL {
+		t.Fatalf("unexpected error code %d", code)
+	}
+	//Extension不足8B的帧格式不能携带响应标记,不回复错误响应
+	config.FrameFormat = FieldFrameFormat{Order: binary.BigEndian, OpaqueBytes: 4, VersionBytes: 2,
+		ExtensionBytes: 4, BodyLenBytes: 4}
+	if err := dispatch(3, 0); !errors.Is(err, ERR_UNKNOWN_CMD) {
+		t.Fatalf("expect unknown cmd %v", err)
+	}
+	if len(client.wchan) != 0 {
+		t.Fatal("narrow frame should not reply error packets")
+	}
+}
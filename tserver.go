@@ -2,25 +2,42 @@ package turbo
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"github.com/blackbeans/logx"
 	"net"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 //turbo日志
 var log = logx.GetLogger("turbo")
 
+//当前平台或者listener不支持热重启传递监听的socket
+var ERR_HANDOFF_UNSUPPORTED = errors.New("LISTENER HANDOFF UNSUPPORTED")
+
+//优雅关闭时连接在该时间内没有读取到新的包才认为对端不再发送请求
+const DRAIN_QUIET_INTERVAL = 100 * time.Millisecond
+
 type TServer struct {
 	ctx        context.Context
 	cancel     context.CancelFunc
 	hostport   string
 	keepalive  time.Duration
 	stopChan   chan bool
-	isShutdown bool
+	isShutdown int32 //1为已经调用Shutdown,CAS保证只关闭一次
 	onMessage  THandler
 	config     *TConfig
 	codec      func() ICodec
+	clients    sync.Map //remoteAddr->*TClient 所有accept的连接
+	lock       sync.Mutex
+	listeners  []net.Listener
+	reaperOnce sync.Once
+	reaperTid  uint32
+	limitOnce  sync.Once
+	limiter    *connLimiter
 }
 
 func NewTServer(hostport string, config *TConfig,
@@ -31,7 +48,7 @@ func NewTServer(hostport string, config *TConfig,
 		hostport:   hostport,
 		stopChan:   make(chan bool, 1),
 		onMessage:  onMessage,
-		isShutdown: false,
+		isShutdown: 0,
 		config:     config,
 		keepalive:  5 * time.Minute,
 		codec: func() ICodec {
@@ -54,7 +71,7 @@ func NewTServerWithCodec(hostport string, config *TConfig, codec func() ICodec,
 		hostport:   hostport,
 		stopChan:   make(chan bool, 1),
 		onMessage:  onMessage,
-		isShutdown: false,
+		isShutdown: 0,
 		config:     config,
 		keepalive:  5 * time.Minute,
 		codec:      codec}
@@ -63,25 +80,110 @@ func NewTServerWithCodec(hostport string, config *TConfig, codec func() ICodec,
 }
 
 func (self *TServer) ListenAndServer() error {
+	return self.listenAndServe(nil)
+}
 
-	addr, err := net.ResolveTCPAddr("tcp4", self.hostport)
+//使用tls监听,双向认证需要在tlsConfig中设置ClientAuth和ClientCAs
+//参考NewServerTLSConfig
+func (self *TServer) ListenAndServerTLS(tlsConfig *tls.Config) error {
+	if nil == tlsConfig {
+		return ERR_NO_TLS_CONFIG
+	}
+	return self.listenAndServe(tlsConfig)
+}
+
+func (self *TServer) listenAndServe(tlsConfig *tls.Config) error {
+
+	//tcp并且没有指定host时同时监听IPv4和IPv6
+	network := self.config.network()
+	addr, err := net.ResolveTCPAddr(network, self.hostport)
 	if nil != err {
-		log.Errorf("TServer|ADDR|FAIL|%s", self.hostport)
+		log.Errorf("TServer|ADDR|FAIL|%s|%s", network, self.hostport)
 		return err
 	}
 
-	listener, err := net.ListenTCP("tcp4", addr)
+	listener, err := net.ListenTCP(network, addr)
 	if nil != err {
 		log.Errorf("TServer|ListenTCP|FAIL|%v|%s", err, addr)
 		return err
 	}
 
-	stopListener := &StoppedListener{listener, self.stopChan, make(chan *net.TCPConn), self.keepalive}
+	return self.serveListener(listener, tlsConfig)
+}
+
+//使用已经绑定的listener,例如systemd传递的fd、SO_REUSEPORT的listener或者测试中的listener
+//和ListenAndServer一样不阻塞,Shutdown时关闭listener
+func (self *TServer) Serve(listener net.Listener) error {
+	return self.serveListener(listener, nil)
+}
+
+//使用已经绑定的listener,连接使用tls包装
+func (self *TServer) ServeTLS(listener net.Listener, tlsConfig *tls.Config) error {
+	if nil == tlsConfig {
+		return ERR_NO_TLS_CONFIG
+	}
+	return self.serveListener(listener, tlsConfig)
+}
+
+func (self *TServer) serveListener(listener net.Listener, tlsConfig *tls.Config) error {
+	stopListener := &StoppedListener{listener, self.stopChan, self.keepalive, tlsConfig}
+	self.addListener(listener)
+	log.Infof("TServer|Serve|%s|%s", listener.Addr().Network(), listener.Addr())
 
 	//开始服务获取连接
 	go self.serve(stopListener)
 	return nil
+}
+
+//监听unix socket,用于同机部署的sidecar
+//如果sockPath已经存在残留的socket文件则先删除
+func (self *TServer) ListenAndServerUnix(sockPath string) error {
+	err := removeStaleSocket(sockPath)
+	if nil != err {
+		log.Errorf("TServer|ListenUnix|RemoveStale|FAIL|%v|%s", err, sockPath)
+		return err
+	}
 
+	addr, err := net.ResolveUnixAddr("unix", sockPath)
+	if nil != err {
+		log.Errorf("TServer|ADDR|FAIL|%s", sockPath)
+		return err
+	}
+
+	listener, err := net.ListenUnix("unix", addr)
+	if nil != err {
+		log.Errorf("TServer|ListenUnix|FAIL|%v|%s", err, sockPath)
+		return err
+	}
+
+	return self.serveListener(listener, nil)
+}
+
+func (self *TServer) addListener(listener net.Listener) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.listeners = append(self.listeners, listener)
+}
+
+//实际监听的地址,监听端口0时可以获取系统分配的端口
+//没有监听返回nil
+func (self *TServer) Addr() net.Addr {
+	addrs := self.Addrs()
+	if len(addrs) <= 0 {
+		return nil
+	}
+	return addrs[0]
+}
+
+//所有listener监听的地址
+func (self *TServer) Addrs() []net.Addr {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	addrs := make([]net.Addr, 0, len(self.listeners))
+	for _, l := range self.listeners {
+		addrs = append(addrs, l.Addr())
+	}
+	return addrs
 }
 
 //networkstat
@@ -89,6 +191,16 @@ func (self *TServer) NetworkStat() NetworkStat {
 	return self.config.FlowStat.Stat()
 }
 
+//所有accept的连接,可以获取每个连接的收发时间
+func (self *TServer) Clients() []*TClient {
+	clients := make([]*TClient, 0, 10)
+	self.clients.Range(func(key, value interface{}) bool {
+		clients = append(clients, value.(*TClient))
+		return true
+	})
+	return clients
+}
+
 //列出来客户端
 func (self *TServer) ListClients() []string {
 	clients := make([]string, 0, 10)
@@ -100,24 +212,138 @@ func (self *TServer) ListClients() []string {
 	return clients
 }
 
+//accept连接直到Shutdown或者listener关闭
+//临时错误(例如fd耗尽)按照指数退避重试
 func (self *TServer) serve(l *StoppedListener) error {
-	for !self.isShutdown {
+	self.startReaper()
+	delay := time.Duration(0)
+	for {
 		conn, err := l.Accept()
 		if nil != err {
-			log.Errorf("TServer|serve|AcceptTCP|FAIL|%s", err)
+			if err == CONN_ERROR || errors.Is(err, net.ErrClosed) {
+				log.Infof("TServer|serve|STOP|%s", l.Addr())
+				return nil
+			}
+			self.config.FlowStat.AcceptErrors.Incr(1)
+			if !isTemporary(err) {
+				log.Errorf("TServer|serve|Accept|FAIL|STOP|%s|%v", l.Addr(), err)
+				return err
+			}
+			delay = acceptBackoff(delay)
+			log.Errorf("TServer|serve|Accept|FAIL|%s|%v|retry after %s", l.Addr(), err, delay)
+			select {
+			case <-time.After(delay):
+			case <-self.stopChan:
+			}
 			continue
 		} else {
-			//创建remotingClient对象
-			tclient := NewTClient(self.ctx, conn, self.codec, self.onMessage, self.config)
-			tclient.Start()
+			delay = 0
+			//超过连接限制的回复繁忙之后关闭,在tls握手之前判断
+			//tls的客户端无法解析明文的繁忙响应,直接关闭
+			ip, err := self.admit(conn)
+			if nil != err {
+				self.reject(conn, err, nil == l.tlsConfig)
+				continue
+			}
+			conn = l.wrap(conn)
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				//握手在单独的协程中进行,不阻塞accept
+				go self.handshake(tlsConn, ip)
+				continue
+			}
+			self.startClient(conn, ip)
 		}
 	}
-	return nil
 }
 
-func (self *TServer) Shutdown() {
-	self.isShutdown = true
+//创建TClient并启动
+func (self *TServer) startClient(conn net.Conn, ip string) {
+	//创建remotingClient对象
+	tclient := NewTClient(self.ctx, conn, self.codec, self.onMessage, self.config)
+	tclient.onClose = func(c *TClient) {
+		self.clients.Delete(c.RemoteAddr())
+		self.release(ip)
+	}
+	//先注册再启动,避免连接立即关闭时onClose先于Store执行而残留
+	self.clients.Store(tclient.RemoteAddr(), tclient)
+	tclient.Start()
+}
+
+//优雅关闭
+//1. 停止accept新的连接
+//2. 给所有连接发送GOAWAY,对端不再发送新的请求
+//3. 等待分发中的请求处理完成、写队列中的响应写出
+//4. 关闭所有连接
+//ctx结束时还没有处理完成则强制关闭,返回ctx.Err()
+func (self *TServer) Shutdown(ctx context.Context) error {
+	//Shutdown和Handoff可能并发调用,只有第一次调用执行关闭
+	if !atomic.CompareAndSwapInt32(&self.isShutdown, 0, 1) {
+		return nil
+	}
 	close(self.stopChan)
+	self.stopReaper()
+	self.lock.Lock()
+	for _, l := range self.listeners {
+		l.Close()
+	}
+	self.lock.Unlock()
+	log.Infof("TServer|Shutdown|Draining...")
+
+	self.clients.Range(func(key, value interface{}) bool {
+		c := value.(*TClient)
+		if !c.IsClosed() {
+			c.goAway()
+		}
+		return true
+	})
+
+	err := self.drain(ctx)
+	if nil != err {
+		log.Warnf("TServer|Shutdown|Drain|FAIL|%v", err)
+	}
+
+	self.clients.Range(func(key, value interface{}) bool {
+		value.(*TClient).Shutdown()
+		return true
+	})
 	self.cancel()
 	log.Infof("TServer|Shutdown...")
+	return err
+}
+
+//等待分发的任务和写队列中的数据处理完成
+func (self *TServer) drain(ctx context.Context) error {
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for !self.drained() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+	return nil
+}
+
+//每个连接满足以下条件之一则认为处理完成:
+//1. 对端已经关闭
+//2. 没有处理中和待写出的包,并且最近DRAIN_QUIET_INTERVAL内没有读取到新的包
+//  (GOAWAY到达对端之前发出的请求还在路上)
+func (self *TServer) drained() bool {
+	if size, _ := self.config.dispool.Monitor(); size > 0 {
+		return false
+	}
+	drained := true
+	self.clients.Range(func(key, value interface{}) bool {
+		c := value.(*TClient)
+		if c.IsClosed() {
+			return true
+		}
+		if atomic.LoadInt32(&c.inflight) > 0 || atomic.LoadInt32(&c.pending) > 0 ||
+			time.Since(c.LastReadTime()) < DRAIN_QUIET_INTERVAL {
+			drained = false
+		}
+		return drained
+	})
+	return drained
 }
@@ -0,0 +1,113 @@
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var ERR_SERVER_BUSY = errors.New("SERVER BUSY")
+
+//拒绝连接时写出繁忙响应的超时
+const REJECT_WRITE_TIMEOUT = 100 * time.Millisecond
+
+//服务端的连接限制
+type connLimiter struct {
+	lock     sync.Mutex
+	total    int
+	perIP    map[string]int
+	maxTotal int
+	maxPerIP int
+	rate     *BurstyLimiter
+}
+
+func newConnLimiter(config *TConfig) *connLimiter {
+	limiter := &connLimiter{
+		perIP:    make(map[string]int),
+		maxTotal: config.MaxConnections,
+		maxPerIP: config.MaxConnectionsPerIP}
+	if config.AcceptRate > 0 {
+		burst := config.AcceptBurst
+		if burst <= 0 {
+			burst = config.AcceptRate
+		}
+		limiter.rate, _ = NewBurstyLimiter(burst, config.AcceptRate)
+	}
+	return limiter
+}
+
+//连接的来源IP,unix socket没有IP
+func sourceIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+//是否接受该连接,返回来源IP用于关闭时释放
+func (self *TServer) admit(conn net.Conn) (string, error) {
+	self.limitOnce.Do(func() {
+		self.limiter = newConnLimiter(self.config)
+	})
+	limiter := self.limiter
+	ip := sourceIP(conn)
+
+	if nil != limiter.rate && !limiter.rate.Acquire() {
+		return ip, fmt.Errorf("%w|accept rate over %d/s", ERR_SERVER_BUSY, limiter.rate.PermitsPerSecond())
+	}
+
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	if limiter.maxTotal > 0 && limiter.total >= limiter.maxTotal {
+		return ip, fmt.Errorf("%w|connections over %d", ERR_SERVER_BUSY, limiter.maxTotal)
+	}
+	if limiter.maxPerIP > 0 && len(ip) > 0 && limiter.perIP[ip] >= limiter.maxPerIP {
+		return ip, fmt.Errorf("%w|connections of %s over %d", ERR_SERVER_BUSY, ip, limiter.maxPerIP)
+	}
+	limiter.total++
+	if len(ip) > 0 {
+		limiter.perIP[ip]++
+	}
+	return ip, nil
+}
+
+//连接关闭,释放计数
+func (self *TServer) release(ip string) {
+	limiter := self.limiter
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limiter.total--
+	if len(ip) > 0 {
+		if limiter.perIP[ip] <= 1 {
+			delete(limiter.perIP, ip)
+		} else {
+			limiter.perIP[ip]--
+		}
+	}
+}
+
+//回复繁忙之后关闭连接,不阻塞accept
+//reply为false时不回复直接关闭
+func (self *TServer) reject(conn net.Conn, reason error, reply bool) {
+	log.Warnf("TServer|serve|REJECT|%s|%v", conn.RemoteAddr(), reason)
+	if nil != self.config.FlowStat {
+		self.config.FlowStat.Rejected.Incr(1)
+	}
+	if !reply {
+		conn.Close()
+		return
+	}
+	go func() {
+		defer conn.Close()
+		p := NewErrorPacket(0, ERR_CODE_BUSY, reason.Error())
+		frame := frameFormat(self.config)
+		buff, err := frame.AppendHeader(make([]byte, 0, frame.HeaderLen()+len(p.Data)), p.Header, int32(len(p.Data)))
+		if nil != err {
+			return
+		}
+		conn.SetDeadline(time.Now().Add(REJECT_WRITE_TIMEOUT))
+		conn.Write(append(buff, p.Data...))
+	}()
+}
@@ -0,0 +1,204 @@
+package turbo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func limitServer(t *testing.T, hostport string, setup func(config *TConfig)) (*TServer, *TConfig) {
+	config := NewTConfig("limit-server:"+hostport, 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100)
+	setup(config)
+	server := NewTServer(hostport, config, delayEcho(0))
+	if err := server.ListenAndServer(); nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Shutdown(context.Background())
+	})
+	return server, config
+}
+
+//读取拒绝连接时的繁忙响应,之后连接应该被关闭
+func readBusy(t *testing.T, conn net.Conn, config *TConfig) {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame := frameFormat(config)
+	hb := make([]byte, frame.HeaderLen())
+	if _, err := io.ReadFull(conn, hb); nil != err {
+		t.Fatalf("read busy header: %v", err)
+	}
+	header, err := frame.UnmarshalHeader(hb)
+	if nil != err {
+		t.Fatal(err)
+	}
+	body := make([]byte, header.BodyLen)
+	if _, err := io.ReadFull(conn, body); nil != err {
+		t.Fatal(err)
+	}
+	code, msg, err := ParseErrorPacket(&Packet{Header: header, Data: body})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if code != ERR_CODE_BUSY {
+		t.Fatalf("code = %d, want ERR_CODE_BUSY|%s", code, msg)
+	}
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("connection should be closed after busy: %v", err)
+	}
+}
+
+//连接正常被接受,能完成一次请求
+func echoOnce(t *testing.T, conn net.Conn, config *TConfig) {
+	p := NewPacket(1, []byte("echo"))
+	p.Header.Opaque = 1
+	frame := frameFormat(config)
+	buff, err := frame.AppendHeader(nil, p.Header, int32(len(p.Data)))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(append(buff, p.Data...)); nil != err {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	hb := make([]byte, frame.HeaderLen())
+	if _, err := io.ReadFull(conn, hb); nil != err {
+		t.Fatalf("accepted connection should be served: %v", err)
+	}
+}
+
+func TestConnLimitTotal(t *testing.T) {
+	server, config := limitServer(t, "localhost:28905", func(config *TConfig) {
+		config.MaxConnections = 2
+	})
+
+	conns := make([]net.Conn, 0, 2)
+	for i := 0; i < 2; i++ {
+		conn, err := dial("localhost:28905")
+		if nil != err {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		echoOnce(t, conn, config)
+		conns = append(conns, conn)
+	}
+
+	conn, err := dial("localhost:28905")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	readBusy(t, conn, config)
+	if rejected := config.FlowStat.Rejected.Count(); rejected != 1 {
+		t.Fatalf("rejected = %d, want 1", rejected)
+	}
+
+	//关闭一个之后可以重新连接
+	conns[0].Close()
+	if !waitFor(2*time.Second, func() bool { return len(server.Clients()) == 1 }) {
+		t.Fatal("closed connection should be released")
+	}
+	conn, err = dial("localhost:28905")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	echoOnce(t, conn, config)
+}
+
+func TestConnLimitPerIP(t *testing.T) {
+	_, config := limitServer(t, "localhost:28906", func(config *TConfig) {
+		config.MaxConnectionsPerIP = 1
+	})
+
+	conn, err := dial("localhost:28906")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	echoOnce(t, conn, config)
+
+	rejected, err := dial("localhost:28906")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+	readBusy(t, rejected, config)
+}
+
+func TestConnLimitAcceptRate(t *testing.T) {
+	_, config := limitServer(t, "localhost:28907", func(config *TConfig) {
+		config.AcceptRate = 1
+		config.AcceptBurst = 1
+	})
+
+	conn, err := dial("localhost:28907")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	echoOnce(t, conn, config)
+
+	rejected, err := dial("localhost:28907")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+	readBusy(t, rejected, config)
+
+	//令牌恢复之后可以再次连接
+	time.Sleep(1100 * time.Millisecond)
+	conn, err = dial("localhost:28907")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	echoOnce(t, conn, config)
+}
+
+//tls的连接在握手之前判断限制,拒绝时直接关闭
+func TestConnLimitTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, caFile, _ := genCert(t, dir, "ca", nil, nil, true)
+	_, _, serverCert, serverKey := genCert(t, dir, "server", ca, caKey, false)
+	serverTLS, err := NewServerTLSConfig(serverCert, serverKey, "")
+	if nil != err {
+		t.Fatal(err)
+	}
+	config := NewTConfig("limit-server:localhost:28917", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100)
+	config.MaxConnections = 1
+	server := NewTServer("localhost:28917", config, delayEcho(0))
+	if err := server.ListenAndServerTLS(serverTLS); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	clientTLS, err := NewClientTLSConfig("", caFile, "", "")
+	if nil != err {
+		t.Fatal(err)
+	}
+	conn, err := DialTLS("localhost:28917", clientTLS, time.Second)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !waitFor(time.Second, func() bool { return len(server.Clients()) == 1 }) {
+		t.Fatal("server should accept the first connection")
+	}
+
+	//没有发送ClientHello,被接受的连接会一直等待握手
+	rejected, err := dial("localhost:28917")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+	rejected.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := rejected.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("rejected tls connection should be closed before handshake: %v", err)
+	}
+	if rejected := config.FlowStat.Rejected.Count(); rejected != 1 {
+		t.Fatalf("rejected = %d, want 1", rejected)
+	}
+}
@@ -0,0 +1,81 @@
+package turbo
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+//空闲连接检查的最大间隔
+const MAX_REAPER_INTERVAL = 1 * time.Second
+
+//开启空闲连接和过期连接的检查
+func (self *TServer) startReaper() {
+	self.reaperOnce.Do(func() {
+		interval := self.reaperInterval()
+		if interval <= 0 {
+			return
+		}
+		tid := self.config.TW.RepeatedTimer(interval, func(tid uint32, t time.Time) {
+			self.reap()
+		}, nil)
+		atomic.StoreUint32(&self.reaperTid, tid)
+	})
+}
+
+func (self *TServer) stopReaper() {
+	if tid := atomic.SwapUint32(&self.reaperTid, 0); tid > 0 {
+		self.config.TW.CancelTimer(tid)
+	}
+}
+
+//检查间隔,取配置的一半,最大MAX_REAPER_INTERVAL
+//都没有配置返回0
+func (self *TServer) reaperInterval() time.Duration {
+	interval := time.Duration(0)
+	for _, d := range []time.Duration{self.config.MaxIdleTime, self.config.MaxConnAge} {
+		if d <= 0 {
+			continue
+		}
+		if d/2 < interval || interval <= 0 {
+			interval = d / 2
+		}
+	}
+	if interval > MAX_REAPER_INTERVAL {
+		interval = MAX_REAPER_INTERVAL
+	}
+	return interval
+}
+
+//关闭空闲的连接,超过最长存活时间的先GOAWAY,正在处理的请求完成并且写队列清空之后关闭
+func (self *TServer) reap() {
+	now := time.Now()
+	self.clients.Range(func(key, value interface{}) bool {
+		c := value.(*TClient)
+		if c.IsClosed() {
+			self.clients.Delete(key)
+			return true
+		}
+
+		lastActive := c.LastReadTime()
+		if w := c.LastWriteTime(); w.After(lastActive) {
+			lastActive = w
+		}
+		if self.config.MaxIdleTime > 0 && now.Sub(lastActive) > self.config.MaxIdleTime {
+			log.Infof("TServer|Reaper|IDLE|%s|%s|CLOSE", c.RemoteAddr(), now.Sub(lastActive))
+			c.shutdown(ERR_IDLE_TIMEOUT)
+			return true
+		}
+
+		if self.config.MaxConnAge > 0 && now.Sub(c.ConnectedTime()) > self.config.MaxConnAge {
+			if atomic.CompareAndSwapInt32(&c.expiring, 0, 1) {
+				//先通知对端不要再发送新的请求,下一次检查再关闭
+				log.Infof("TServer|Reaper|EXPIRED|%s|%s|GOAWAY", c.RemoteAddr(), now.Sub(c.ConnectedTime()))
+				c.goAway()
+			} else if atomic.LoadInt32(&c.inflight) <= 0 && atomic.LoadInt32(&c.pending) <= 0 {
+				log.Infof("TServer|Reaper|EXPIRED|%s|CLOSE", c.RemoteAddr())
+				c.shutdown(ERR_CONN_EXPIRED)
+			}
+		}
+		return true
+	})
+}
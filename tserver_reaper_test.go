@@ -0,0 +1,99 @@
+package turbo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+//等待条件成立
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return true
+}
+
+func TestReapIdle(t *testing.T) {
+	//连接建立之前的时间
+	start := time.Now()
+	server, client := newTestPair(t, "localhost:28903", delayEcho(0), func(config *TConfig) {
+		config.MaxIdleTime = 500 * time.Millisecond
+	})
+
+	p := NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	if _, err := client.WriteAndGet(*p, time.Second); nil != err {
+		t.Fatal(err)
+	}
+	if !waitFor(time.Second, func() bool { return len(server.Clients()) == 1 }) {
+		t.Fatal("server should track the client")
+	}
+	c := server.Clients()[0]
+	if c.ConnectedTime().Before(start) || c.LastReadTime().Before(c.ConnectedTime()) ||
+		c.LastWriteTime().Before(c.ConnectedTime()) {
+		t.Fatalf("unexpected timestamps %s|%s|%s", c.ConnectedTime(), c.LastReadTime(), c.LastWriteTime())
+	}
+
+	//空闲超过MaxIdleTime之后关闭
+	if !waitFor(3*time.Second, client.IsClosed) {
+		t.Fatal("idle connection should be closed")
+	}
+	if time.Since(start) < 500*time.Millisecond {
+		t.Fatalf("connection closed too early %s", time.Since(start))
+	}
+	if !waitFor(time.Second, func() bool { return len(server.Clients()) == 0 }) {
+		t.Fatal("closed client should be removed")
+	}
+	if !errors.Is(c.CloseReason(), ERR_IDLE_TIMEOUT) {
+		t.Fatalf("unexpected close reason %v", c.CloseReason())
+	}
+}
+
+func TestReapExpired(t *testing.T) {
+	server, client := newTestPair(t, "localhost:28904", delayEcho(0), func(config *TConfig) {
+		config.MaxConnAge = 300 * time.Millisecond
+	})
+	if !waitFor(time.Second, func() bool { return len(server.Clients()) == 1 }) {
+		t.Fatal("server should track the client")
+	}
+	c := server.Clients()[0]
+
+	if !waitFor(3*time.Second, client.IsGoaway) {
+		t.Fatal("expired connection should receive GOAWAY")
+	}
+	if !waitFor(3*time.Second, client.IsClosed) {
+		t.Fatal("expired connection should be closed")
+	}
+	if !errors.Is(c.CloseReason(), ERR_CONN_EXPIRED) {
+		t.Fatalf("unexpected close reason %v", c.CloseReason())
+	}
+}
+
+//处理器还没有返回的请求在关闭之前完成响应
+func TestReapExpiredInflight(t *testing.T) {
+	server, client := newTestPair(t, "localhost:28914", delayEcho(1500*time.Millisecond), func(config *TConfig) {
+		config.MaxConnAge = 300 * time.Millisecond
+	})
+	if !waitFor(time.Second, func() bool { return len(server.Clients()) == 1 }) {
+		t.Fatal("server should track the client")
+	}
+	c := server.Clients()[0]
+
+	p := NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	resp, err := client.WriteAndGet(*p, 5*time.Second)
+	if nil != err || string(resp.([]byte)) != "echo" {
+		t.Fatalf("in-flight request should be answered %v|%v", resp, err)
+	}
+	if !waitFor(3*time.Second, client.IsClosed) {
+		t.Fatal("expired connection should be closed")
+	}
+	if !errors.Is(c.CloseReason(), ERR_CONN_EXPIRED) {
+		t.Fatalf("unexpected close reason %v", c.CloseReason())
+	}
+}
@@ -192,3 +192,211 @@ func dial(hostport string) (*net.TCPConn, error) {
 
 	return conn, nil
 }
+
+//优雅关闭等待处理中的请求完成
+func TestServerGracefulShutdown(t *testing.T) {
+	server := NewTServer("localhost:28891", NewTConfig(
+		"turbo-server:localhost:28891",
+		100, 16*1024,
+		16*1024, 100, 100,
+		10*time.Second,
+		1000), func(ctx *TContext) error {
+		p := ctx.Message
+		//模拟慢请求
+		time.Sleep(300 * time.Millisecond)
+		resp := NewRespPacket(p.Header.Opaque, p.Header.CmdType, nil)
+		resp.PayLoad = p.Data
+		ctx.Client.Write(*resp)
+		return nil
+	})
+	server.ListenAndServer()
+
+	conn, err := dial("localhost:28891")
+	if nil != err {
+		t.Fatal(err)
+	}
+	client := NewTClient(context.Background(), conn, func() ICodec {
+		return LengthBytesCodec{MaxFrameLength: MAX_PACKET_BYTES}
+	}, func(ctx *TContext) error {
+		ctx.Client.Attach(ctx.Message.Header.Opaque, ctx.Message.Data)
+		return nil
+	}, NewTConfig(
+		"turbo-client:localhost:28891",
+		100, 16*1024,
+		16*1024, 100, 100,
+		10*time.Second,
+		1000))
+	client.Start()
+	defer client.Shutdown()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); nil != err {
+			t.Errorf("shutdown not drained %v", err)
+		}
+	}()
+
+	p := NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	resp, err := client.WriteAndGet(*p, time.Second)
+	if nil != err {
+		t.Fatalf("in-flight request should finish %v", err)
+	}
+	if string(resp.([]byte)) != "echo" {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if !client.IsGoaway() {
+		t.Fatal("client should receive GOAWAY")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if !client.IsClosed() {
+		t.Fatal("client should be closed after drain")
+	}
+	if _, err := dial("localhost:28891"); nil == err {
+		t.Fatal("server should stop accepting")
+	}
+}
+
+//没有IPv6的环境跳过
+func skipWithoutIPv6(t *testing.T) {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if nil != err {
+		t.Skipf("ipv6 unavailable %v", err)
+	}
+	l.Close()
+}
+
+func TestListenIPv6(t *testing.T) {
+	skipWithoutIPv6(t)
+	config := NewTConfig("turbo-server:ipv6", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100)
+	config.Network = "tcp6"
+	server := NewTServer("[::1]:28911", config, delayEcho(0))
+	if err := server.ListenAndServer(); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	client, err := Dial(context.Background(), "[::1]:28911", dialOptions("turbo-client:ipv6"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+	echo(t, client)
+	if client.RemoteAddr() != "[::1]:28911" {
+		t.Fatalf("unexpected remote addr %s", client.RemoteAddr())
+	}
+	if host, _, err := net.SplitHostPort(client.LocalAddr()); nil != err || host != "::1" {
+		t.Fatalf("unexpected local addr %s|%v", client.LocalAddr(), err)
+	}
+
+	//tcp6的服务端不接受IPv4
+	if _, err := dial("127.0.0.1:28911"); nil == err {
+		t.Fatal("tcp6 server should not accept ipv4")
+	}
+}
+
+func TestListenDualStack(t *testing.T) {
+	skipWithoutIPv6(t)
+	server := NewTServer(":28912", NewTConfig("turbo-server:dual", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100), delayEcho(0))
+	if err := server.ListenAndServer(); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	for _, addr := range []string{"127.0.0.1:28912", "[::1]:28912"} {
+		client, err := Dial(context.Background(), addr, dialOptions("turbo-client:"+addr))
+		if nil != err {
+			t.Fatal(err)
+		}
+		echo(t, client)
+		if client.RemoteAddr() != addr {
+			t.Fatalf("unexpected remote addr %s|%s", client.RemoteAddr(), addr)
+		}
+		client.Shutdown()
+	}
+}
+
+func TestServeListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	server := NewTServer("", NewTConfig("turbo-server:serve", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100), delayEcho(0))
+	if err := server.Serve(l); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	//端口0由系统分配
+	addr, ok := server.Addr().(*net.TCPAddr)
+	if !ok || addr.Port == 0 || addr.String() != l.Addr().String() {
+		t.Fatalf("unexpected bound addr %v", server.Addr())
+	}
+	client, err := Dial(context.Background(), addr.String(), dialOptions("turbo-client:serve"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+	echo(t, client)
+}
+
+//内存中的listener
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+}
+
+func (self *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-self.conns:
+		return c, nil
+	case <-self.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (self *pipeListener) Close() error {
+	close(self.done)
+	return nil
+}
+
+func (self *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (self *pipeListener) dial() net.Conn {
+	c, s := net.Pipe()
+	self.conns <- s
+	return c
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestServePipeListener(t *testing.T) {
+	l := &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+	server := NewTServer("", NewTConfig("turbo-server:pipe", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100), delayEcho(0))
+	if err := server.Serve(l); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	options := dialOptions("turbo-client:pipe")
+	for i := 0; i < 2; i++ {
+		client := NewTClient(context.Background(), l.dial(), func() ICodec {
+			return LengthBytesCodec{MaxFrameLength: MAX_PACKET_BYTES}
+		}, options.Handler, options.Config)
+		client.Start()
+		defer client.Shutdown()
+		echo(t, client)
+	}
+	//两端地址相同的连接也可以区分
+	if !waitFor(time.Second, func() bool { return len(server.Clients()) == 2 }) {
+		t.Fatalf("pipe clients should be tracked separately %d", len(server.Clients()))
+	}
+}
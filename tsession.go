@@ -2,41 +2,80 @@ package turbo
 
 import (
 	"bufio"
-	"bytes"
+	"errors"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 //turbo session
 type TSession struct {
-	conn       *net.TCPConn //tcp的session
-	remoteAddr string
-	br         *bufio.Reader
-	bw         *bufio.Writer
-	isClose    bool
-	lasttime   uint32
-	config     *TConfig
-	onMessage  IOHandler
+	//atomic访问的int64放在最前面保证32位平台的对齐
+	lastRead    int64    //最后一次读取的时间,UnixNano
+	lastWrite   int64    //最后一次写出的时间,UnixNano
+	conn        net.Conn //tcp或者tls的session
+	remoteAddr  string
+	br          *bufio.Reader
+	bw          *bufio.Writer
+	isClose     int32     //1为已关闭,atomic访问,在closeReason之后写入
+	closed      chan bool //关闭时close,唤醒等待的写协程
+	closeOnce   sync.Once
+	closeReason error     //连接关闭的原因
+	createTime  time.Time //连接建立的时间
+	config      *TConfig
+	onMessage   IOHandler
+	onClose     func(reason error) //连接关闭的回调
+	onError     func(err error)    //读取失败的回调
+	frame       FrameFormat
+	header      []byte //读取包头的缓冲,只在读取协程中使用
+	vectored    bool   //连接是否支持writev
+	hbuf        []byte //写出包头的缓冲,只在写出协程中使用
+	buffers     net.Buffers
+	written     []*Packet
+	maxInbound  int //接收的最大包体字节数
+	maxOutbound int //发送的最大包体字节数
 }
 
-func NewSession(conn *net.TCPConn, config *TConfig,
+func NewSession(conn net.Conn, config *TConfig,
+	onMsg IOHandler) *TSession {
+	_, remoteAddr := connAddrs(conn)
+	return newSession(conn, remoteAddr, config, onMsg)
+}
+
+func newSession(conn net.Conn, remoteAddr string, config *TConfig,
 	onMsg IOHandler) *TSession {
 
-	conn.SetKeepAlive(true)
-	conn.SetKeepAlivePeriod(config.IdleTime * 2)
-	//禁用nagle
-	conn.SetNoDelay(true)
-	conn.SetReadBuffer(config.ReadBufferSize)
-	conn.SetWriteBuffer(config.WriteBufferSize)
+	//tls包装过的连接在包装之前已经设置过socket参数
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(config.IdleTime * 2)
+		//禁用nagle
+		tcpConn.SetNoDelay(true)
+		tcpConn.SetReadBuffer(config.ReadBufferSize)
+		tcpConn.SetWriteBuffer(config.WriteBufferSize)
+	}
 
+	frame := frameFormat(config)
+	now := time.Now().UnixNano()
 	session := &TSession{
-		conn:       conn,
-		br:         bufio.NewReaderSize(conn, config.ReadBufferSize),
-		bw:         bufio.NewWriterSize(conn, config.WriteBufferSize),
-		isClose:    false,
-		remoteAddr: conn.RemoteAddr().String(),
-		onMessage:  onMsg,
-		config:     config}
+		lastRead:    now,
+		lastWrite:   now,
+		createTime:  time.Unix(0, now),
+		conn:        conn,
+		br:          bufio.NewReaderSize(conn, config.ReadBufferSize),
+		bw:          bufio.NewWriterSize(conn, config.WriteBufferSize),
+		isClose:     0,
+		closed:      make(chan bool),
+		remoteAddr:  remoteAddr,
+		onMessage:   onMsg,
+		frame:       frame,
+		header:      make([]byte, frame.HeaderLen()),
+		vectored:    isVectored(conn),
+		maxInbound:  frameLimit(config.MaxInboundFrame, nil),
+		maxOutbound: frameLimit(config.MaxOutboundFrame, nil),
+		config:      config}
 	//连接数计数
 	config.FlowStat.Connections.Incr(1)
 	//记录下当期那
@@ -48,13 +87,28 @@ func (self *TSession) RemotingAddr() string {
 	return self.remoteAddr
 }
 
+//最后一次读取和写出都超过了IdleTime则认为空闲
 func (self *TSession) Idle() bool {
-	//当前时间如果大于 最后一次发包时间+2倍的idletime 则认为空心啊
-	if uint32(time.Now().Unix())-
-		(self.lasttime+uint32(self.config.IdleTime/time.Second)) > 0 {
-		return true
+	last := atomic.LoadInt64(&self.lastRead)
+	if w := atomic.LoadInt64(&self.lastWrite); w > last {
+		last = w
 	}
-	return false
+	return time.Since(time.Unix(0, last)) > self.config.IdleTime
+}
+
+//连接建立的时间
+func (self *TSession) CreateTime() time.Time {
+	return self.createTime
+}
+
+//最后一次读取到包的时间
+func (self *TSession) LastReadTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&self.lastRead))
+}
+
+//最后一次写出的时间
+func (self *TSession) LastWriteTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&self.lastWrite))
 }
 
 //读取
@@ -62,7 +116,7 @@ func (self *TSession) Open() {
 
 	go func() {
 		//缓存本次包的数据
-		for !self.isClose {
+		for !self.Closed() {
 
 			err := func() error {
 				defer func() {
@@ -71,153 +125,227 @@ func (self *TSession) Open() {
 					}
 				}()
 
-				//按照标准的turbo packet读取packet头部
-				buff, err := self.read0(self.br, PACKET_HEAD_LEN)
+				//按照配置的帧格式读取packet头部
+				headLen := len(self.header)
+				err := self.read0(self.br, self.header)
 				if nil != err {
-					self.Close()
+					err = newTurboError(ErrKindBroken, err, self.remoteAddr, 0, 0)
+					//对端正常关闭或者本地主动关闭的不算错误
+					if !self.Closed() && !errors.Is(err, io.EOF) && nil != self.onError {
+						self.onError(err)
+					}
 					return err
 				}
-				self.lasttime = uint32(time.Now().Unix())
-				br := bytes.NewReader(buff)
-				head, err := UnmarshalHeader(br)
+				atomic.StoreInt64(&self.lastRead, time.Now().UnixNano())
+				head, err := self.frame.UnmarshalHeader(self.header)
 				if nil != err {
 					log.Errorf("TSession|UnmarshalHeader|%s|FAIL|CLOSE SESSION|%v",
 						self.remoteAddr, err)
+					err = newTurboError(ErrKindCodec, err, self.remoteAddr, head.Opaque, head.CmdType)
 					self.onMessage(Packet{Header: head, Data: nil}, err)
 					return err
 				}
 
-				if head.BodyLen > MAX_PACKET_BYTES {
-					log.Errorf("TSession|UnmarshalHeader|%s|Too Large Packet|CLOSE SESSION|%v",
-						self.remoteAddr, head.BodyLen)
+				if int(head.BodyLen) > self.maxInbound {
+					log.Errorf("TSession|UnmarshalHeader|%s|Too Large Packet|DISCARD|%d/%d",
+						self.remoteAddr, head.BodyLen, self.maxInbound)
+					//丢弃body,连接继续可用
+					if _, err := self.br.Discard(int(head.BodyLen)); nil != err {
+						log.Errorf("TSession|Discard|%s|FAIL|CLOSE SESSION|%v", self.remoteAddr, err)
+						return err
+					}
 					//构造一个error的响应包
-					self.onMessage(Packet{Header: head, Data: nil}, ERR_TOO_LARGE_PACKET)
-					return ERR_TOO_LARGE_PACKET
+					self.onMessage(Packet{Header: head, Data: nil},
+						newTurboError(ErrKindTooLarge, ERR_TOO_LARGE_PACKET, self.remoteAddr, head.Opaque, head.CmdType))
+					return nil
 				}
 
-				//读取body
-				body, err := self.read0(self.br, int(head.BodyLen))
+				//读取body,使用缓冲池中的缓冲
+				p := Packet{Header: head}
+				if head.BodyLen > 0 {
+					p.buff = getBuffer(int(head.BodyLen))
+					p.Data = (*p.buff)[:head.BodyLen]
+				}
+				err = self.read0(self.br, p.Data)
 				if nil != err {
+					p.Release()
 					log.Errorf("TSession|ReadBody|%s|FAIL|CLOSE SESSION|%v|bodyLen:%d",
 						self.remoteAddr, err, head.BodyLen)
 					//构造一个error的读取包
+					err = newTurboError(ErrKindBroken, err, self.remoteAddr, head.Opaque, head.CmdType)
 					self.onMessage(Packet{Header: head, Data: nil}, err)
 					return err
 				}
 
-				p := Packet{Header: head, Data: body}
+				//压缩过的body先解压
+				if c := head.Compress(); c != COMPRESS_NONE {
+					body, err := decompressBody(c, p.Data, self.maxInbound)
+					//解压之后的数据不在缓冲池中
+					p.Release()
+					if nil != err {
+						log.Errorf("TSession|Decompress|%s|FAIL|%v|compress:%d", self.remoteAddr, err, c)
+						self.onMessage(Packet{Header: head, Data: nil},
+							newTurboError(ErrKindCodec, err, self.remoteAddr, head.Opaque, head.CmdType))
+						return nil
+					}
+					p.Header.SetCompress(COMPRESS_NONE)
+					p.Data = body
+				}
+
 				//回调上层
 				self.onMessage(p, nil)
 				if nil != self.config.FlowStat {
 					self.config.FlowStat.ReadFlow.Incr(1)
-					self.config.FlowStat.ReadBytesFlow.Incr(PACKET_HEAD_LEN + head.BodyLen)
+					self.config.FlowStat.ReadBytesFlow.Incr(int32(headLen) + head.BodyLen)
 				}
 				return nil
 			}()
 			if nil != err {
+				//读取失败的连接不再可用
+				self.closeWith(err)
 				break
 			}
 		}
 	}()
 }
 
-//分段读取
-func (self *TSession) read0(br *bufio.Reader, len int) ([]byte, error) {
-	//按照标准的turbo packet读取packet头部
-	buff := make([]byte, len)
-	idx := 0
-	for {
-		l, err := br.Read(buff[idx:])
-		if nil != err {
-			log.Errorf("TSession|Open|%s|FAIL|CLOSE SESSION|%s", self.remoteAddr, err)
-			return nil, err
-		}
-		idx += l
-		if idx >= len {
-			break
-		}
+//读满buff
+func (self *TSession) read0(br *bufio.Reader, buff []byte) error {
+	if _, err := io.ReadFull(br, buff); nil != err {
+		log.Errorf("TSession|Open|%s|FAIL|CLOSE SESSION|%s", self.remoteAddr, err)
+		return err
 	}
-	return buff, nil
+	return nil
+}
 
+//一次合并写出的最大包数
+const MAX_WRITE_BATCH = 128
+
+//支持writev的连接
+func isVectored(conn net.Conn) bool {
+	switch conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		return true
+	}
+	return false
 }
 
 //写数据
+//支持writev的连接包头和body通过net.Buffers直接写出,不再拷贝body
+//其他连接(例如tls)写入bufio之后flush
 func (self *TSession) Write(tlv ...*Packet) error {
 
-	self.lasttime = uint32(time.Now().Unix())
-	batch := make([]byte, 0, len(tlv)*128)
+	atomic.StoreInt64(&self.lastWrite, time.Now().UnixNano())
+	//包头写入同一个缓冲,容量足够时append不会重新分配
+	need := len(tlv) * self.frame.HeaderLen()
+	if cap(self.hbuf) < need {
+		self.hbuf = make([]byte, 0, need)
+	}
+	hbuf := self.hbuf[:0]
+	buffers := self.buffers[:0]
+	written := self.written[:0]
+	total := 0
 	for _, t := range tlv {
-		p := t.Marshal()
-		if nil == p || len(p) <= 0 {
-			log.Errorf("TSession|asyncWrite|MarshalPayload|FAIL|EMPTY PACKET")
-			if nil != t.OnComplete {
-				t.OnComplete(ERR_MARSHAL_PACKET)
-			}
-			//如果是同步写出
+		//如果大小超过了最大值那么久写入失败
+		if len(t.Data) > self.maxOutbound {
+			log.Errorf("TSession|asyncWrite|MarshalPayload|FAIL|MAX_PACKET_BYTES|%d/%d",
+				len(t.Data), self.maxOutbound)
+			t.complete(newTurboError(ErrKindTooLarge, ERR_TOO_LARGE_PACKET,
+				self.remoteAddr, t.Header.Opaque, t.Header.CmdType))
 			continue
 		}
 
-		//如果大小超过了最大值那么久写入失败
-		if t.Header.BodyLen > MAX_PACKET_BYTES {
-			if nil != t.OnComplete {
-				log.Errorf("TSession|asyncWrite|MarshalPayload|FAIL|MAX_PACKET_BYTES|%d/%d",
-					t.Header.BodyLen, MAX_PACKET_BYTES)
-				t.OnComplete(ERR_TOO_LARGE_PACKET)
-			}
+		start := len(hbuf)
+		var err error
+		hbuf, err = self.frame.AppendHeader(hbuf, t.Header, int32(len(t.Data)))
+		if nil != err {
+			log.Errorf("TSession|asyncWrite|MarshalHeader|%s|FAIL|%v", self.remoteAddr, err)
+			t.complete(newTurboError(ErrKindCodec, err,
+				self.remoteAddr, t.Header.Opaque, t.Header.CmdType))
 			continue
-		} else {
-			//成功写出去了
-			t.OnComplete(nil)
 		}
-		batch = append(batch, p...)
-	}
-
-	if len(batch) <= 0 {
-		return nil
+		buffers = append(buffers, hbuf[start:])
+		if len(t.Data) > 0 {
+			buffers = append(buffers, t.Data)
+		}
+		written = append(written, t)
+		total += len(hbuf) - start + len(t.Data)
 	}
 
-	l := 0
-	tmp := batch
-	for {
-		length, err := self.bw.Write(tmp)
-		if nil != err {
-			log.Errorf("TSession|asyncWrite|conn|%s|FAIL|%s|%d/%d", self.remoteAddr, err, length, len(tmp))
-			return err
+	var err error
+	if total > 0 {
+		if self.vectored {
+			//WriteTo会消费掉self.buffers,本地的buffers保留完整的切片
+			self.buffers = buffers
+			_, err = self.buffers.WriteTo(self.conn)
+		} else {
+			for _, b := range buffers {
+				if _, err = self.bw.Write(b); nil != err {
+					break
+				}
+			}
+			if nil == err {
+				err = self.bw.Flush()
+			}
 		}
+	}
+	if nil != err {
+		log.Errorf("TSession|asyncWrite|conn|%s|FAIL|%s|%d", self.remoteAddr, err, total)
+		err = newTurboError(ErrKindBroken, err, self.remoteAddr, 0, 0)
+	}
 
-		l += length
-		//write finish
-		if l == len(batch) {
-			break
-		}
-		tmp = batch[l:]
+	for i, t := range written {
+		t.complete(err)
+		written[i] = nil
 	}
-	// //flush
-	self.bw.Flush()
-	if nil != self.config.FlowStat {
-		self.config.FlowStat.WriteFlow.Incr(int32(len(tlv)))
-		self.config.FlowStat.WriteBytesFlow.Incr(int32(len(batch)))
+	//不持有body的引用
+	for i := range buffers {
+		buffers[i] = nil
 	}
-	return nil
+	self.buffers = buffers[:0]
+	self.written = written[:0]
+
+	if nil == err && nil != self.config.FlowStat && total > 0 {
+		self.config.FlowStat.WriteFlow.Incr(int32(len(written)))
+		self.config.FlowStat.WriteBytesFlow.Incr(int32(total))
+	}
+	return err
 }
 
 //当前连接是否关闭
 func (self *TSession) Closed() bool {
-	return self.isClose
+	return atomic.LoadInt32(&self.isClose) == 1
 }
 
+//连接关闭的原因,没有关闭返回nil
+func (self *TSession) CloseReason() error {
+	if !self.Closed() {
+		return nil
+	}
+	return self.closeReason
+}
+
+//本地主动关闭
 func (self *TSession) Close() error {
+	return self.closeWith(ERR_CLOSED_LOCAL)
+}
 
-	if !self.isClose {
-		self.isClose = true
-		//flush
-		self.bw.Flush()
+//关闭连接并记录原因,只有第一次关闭生效
+func (self *TSession) closeWith(reason error) error {
+	self.closeOnce.Do(func() {
+		self.closeReason = reason
+		atomic.StoreInt32(&self.isClose, 1)
+		close(self.closed)
+		//写协程每次写出之后已经flush,这里flush会和写协程并发使用bw
 		self.conn.Close()
 		self.config.FlowStat.Connections.Incr(-1)
 		//清理掉这个Clients
 		self.config.FlowStat.Clients.Delete(self.remoteAddr)
-		log.Infof("TSession|Close|%s...", self.remoteAddr)
-	}
+		if nil != self.onClose {
+			self.onClose(reason)
+		}
+		log.Infof("TSession|Close|%s|%v...", self.remoteAddr, reason)
+	})
 
 	return nil
 }
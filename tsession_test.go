@@ -0,0 +1,160 @@
+package turbo
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+//连接到一个丢弃所有数据的tcp server
+func discardConn(b *testing.B) net.Conn {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if nil != err {
+		b.Fatal(err)
+	}
+	go func() {
+		conn, err := l.Accept()
+		l.Close()
+		if nil != err {
+			return
+		}
+		io.Copy(ioutil.Discard, conn)
+		conn.Close()
+	}()
+	conn, err := net.Dial("tcp4", l.Addr().String())
+	if nil != err {
+		b.Fatal(err)
+	}
+	return conn
+}
+
+func TestSessionWrite(t *testing.T) {
+	config := NewTConfig("test", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100)
+	for _, vectored := range []bool{true, false} {
+		r, w := net.Pipe()
+		s := NewSession(w, config, nil)
+		s.vectored = vectored
+		//超过最大包大小的不写出
+		s.maxOutbound = 5
+
+		results := make(chan error, 3)
+		packets := make([]*Packet, 0, 3)
+		for i, body := range []string{"hello", "toolong", "world"} {
+			p := NewRespPacket(uint32(i+1), 1, []byte(body))
+			p.OnComplete = func(err error) {
+				results <- err
+			}
+			packets = append(packets, p)
+		}
+		go s.Write(packets...)
+
+		header := make([]byte, PACKET_HEAD_LEN)
+		for _, expect := range []string{"hello", "world"} {
+			if _, err := io.ReadFull(r, header); nil != err {
+				t.Fatal(err)
+			}
+			h, _ := DefaultFrameFormat.UnmarshalHeader(header)
+			body := make([]byte, h.BodyLen)
+			io.ReadFull(r, body)
+			if string(body) != expect {
+				t.Fatalf("vectored:%v|unexpected body %s", vectored, body)
+			}
+		}
+
+		tooLarge := 0
+		for i := 0; i < 3; i++ {
+			if err := <-results; errors.Is(err, ERR_TOO_LARGE_PACKET) {
+				tooLarge++
+			} else if nil != err {
+				t.Fatal(err)
+			}
+		}
+		if tooLarge != 1 {
+			t.Fatalf("vectored:%v|unexpected too large %d", vectored, tooLarge)
+		}
+		r.Close()
+		s.Close()
+	}
+}
+
+//按照合并写出之前的方式,拷贝到一个batch之后写入bufio
+func legacyWrite(s *TSession, tlv ...*Packet) error {
+	batch := make([]byte, 0, len(tlv)*128)
+	for _, t := range tlv {
+		buff := make([]byte, 0, s.frame.HeaderLen()+len(t.Data))
+		buff, _ = s.frame.AppendHeader(buff, t.Header, int32(len(t.Data)))
+		batch = append(batch, append(buff, t.Data...)...)
+	}
+	if _, err := s.bw.Write(batch); nil != err {
+		return err
+	}
+	return s.bw.Flush()
+}
+
+func benchmarkSessionWrite(b *testing.B, bodyLen int, write func(s *TSession, tlv ...*Packet) error) {
+	config := NewTConfig("bench", 10, 256*1024, 256*1024, 100, 100, 10*time.Second, 100)
+	s := NewSession(discardConn(b), config, nil)
+	defer s.Close()
+
+	//每次写出32个包
+	packets := make([]*Packet, 32)
+	for i := range packets {
+		packets[i] = NewPacket(1, make([]byte, bodyLen))
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packets) * (PACKET_HEAD_LEN + bodyLen)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := write(s, packets...); nil != err {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSessionWriteLegacy1K(b *testing.B) {
+	benchmarkSessionWrite(b, 1024, legacyWrite)
+}
+
+func BenchmarkSessionWrite1K(b *testing.B) {
+	benchmarkSessionWrite(b, 1024, (*TSession).Write)
+}
+
+func BenchmarkSessionWriteLegacy16K(b *testing.B) {
+	benchmarkSessionWrite(b, 16*1024, legacyWrite)
+}
+
+func BenchmarkSessionWrite16K(b *testing.B) {
+	benchmarkSessionWrite(b, 16*1024, (*TSession).Write)
+}
+
+//关闭的同时读取关闭原因,配合-race检查
+func TestSessionCloseReason(t *testing.T) {
+	config := NewTConfig("test", 10, 16*1024, 16*1024, 100, 100, 10*time.Second, 100)
+	r, w := net.Pipe()
+	defer r.Close()
+	s := NewSession(w, config, nil)
+	if nil != s.CloseReason() || s.Closed() {
+		t.Fatal("open session should not have a close reason")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for !s.Closed() {
+			s.CloseReason()
+		}
+	}()
+	s.closeWith(ERR_IDLE_TIMEOUT)
+	<-done
+	if !errors.Is(s.CloseReason(), ERR_IDLE_TIMEOUT) {
+		t.Fatalf("unexpected close reason %v", s.CloseReason())
+	}
+	//只有第一次关闭生效
+	s.Close()
+	if !errors.Is(s.CloseReason(), ERR_IDLE_TIMEOUT) {
+		t.Fatalf("close reason should not change %v", s.CloseReason())
+	}
+}
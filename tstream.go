@@ -0,0 +1,544 @@
+package turbo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//大包分片的默认大小
+const DEFAULT_STREAM_CHUNK_SIZE = 256 * 1024
+
+//接收的大包合并之后默认最大64MB
+const DEFAULT_MAX_STREAM_BYTES = 64 * 1024 * 1024
+
+//分片写队列的大小,普通的包优先写出,避免一个大包占满连接
+const STREAM_CHANNEL_SIZE = 4
+
+//每个连接默认同时接收16个大包
+const DEFAULT_MAX_STREAMS = 16
+
+//默认30s没有收到分片的大包中断接收
+const DEFAULT_STREAM_IDLE_TIMEOUT = 30 * time.Second
+
+//分片序号的字节数
+const STREAM_SEQ_BYTES = 4
+
+var ERR_STREAM_ABORTED = errors.New("STREAM ABORTED")
+var ERR_STREAM_SEQ = errors.New("STREAM CHUNK OUT OF ORDER")
+var ERR_TOO_MANY_STREAMS = errors.New("TOO MANY STREAMS")
+var ERR_STREAM_OVERFLOW = errors.New("STREAM HANDLER FALLS BEHIND")
+var ERR_STREAM_IDLE = errors.New("STREAM IDLE TIMEOUT")
+
+//把r中的数据按照分片写出,接收方合并为一个包(或者以io.Reader交给StreamHandler)
+//分片共用p的Opaque、CmdType、Version、Extension
+//分片的body为 |Seq(4B)|Data|,最后一个分片带有EXT_CHUNK_END标记
+//写出失败或者ctx结束会发送中断标记
+//帧格式的Extension不足8B返回ERR_NARROW_EXTENSION
+func (self *TClient) WriteStream(ctx context.Context, p *Packet, r io.Reader) error {
+	self.fillOpaque(p)
+	return self.writeStream(ctx, p, r)
+}
+
+//以分片写出请求并等待响应
+//超时取ctx的deadline和TConfig.RequestTimeout中较早的
+func (self *TClient) WriteStreamAndGet(ctx context.Context, p *Packet, r io.Reader) (interface{}, error) {
+	opaque := self.fillOpaque(p)
+	timeout, ctxBound := self.requestTimeout(ctx)
+	future := NewFuture(opaque, timeout, self.remoteAddr, self.ctx)
+	future.cmdType = p.Header.CmdType
+	//先挂上future,避免响应先于写完返回
+	tchan := self.config.RequestHolder.Attach(opaque, future)
+	if err := self.writeStream(ctx, p, r); nil != err {
+		self.config.RequestHolder.Remove(opaque)
+		return nil, err
+	}
+	return self.getContext(ctx, future, tchan, ctxBound)
+}
+
+func (self *TClient) writeStream(ctx context.Context, p *Packet, r io.Reader) error {
+	//帧格式携带不了分片标记
+	if !carryFlags(frameFormat(self.config)) {
+		return ERR_NARROW_EXTENSION
+	}
+	chunkSize := self.config.StreamChunkSize
+	if max := self.session().maxOutbound - STREAM_SEQ_BYTES; chunkSize <= 0 || chunkSize > max {
+		chunkSize = max
+	}
+
+	//分片写出的错误
+	errc := make(chan error, 1)
+	onComplete := func(err error) {
+		if nil != err {
+			select {
+			case errc <- err:
+			default:
+			}
+		}
+	}
+
+	var seq uint32
+	for {
+		data := make([]byte, STREAM_SEQ_BYTES+chunkSize)
+		n, err := io.ReadFull(r, data[STREAM_SEQ_BYTES:])
+		flag := EXT_CHUNK
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			flag |= EXT_CHUNK_END
+		} else if nil != err {
+			log.Errorf("TClient|WriteStream|Read|FAIL|%s|%v", self.remoteAddr, err)
+			self.abortStream(p, seq)
+			return err
+		}
+
+		binary.BigEndian.PutUint32(data, seq)
+		chunk := self.newChunk(p, flag, data[:STREAM_SEQ_BYTES+n])
+		chunk.OnComplete = onComplete
+		if err := self.writeChunk(ctx, chunk, errc); nil != err {
+			log.Errorf("TClient|WriteStream|FAIL|%s|opaque:%d|seq:%d|%v",
+				self.remoteAddr, p.Header.Opaque, seq, err)
+			self.abortStream(p, seq)
+			return err
+		}
+		if flag&EXT_CHUNK_END != 0 {
+			return nil
+		}
+		seq++
+	}
+}
+
+//构造分片
+func (self *TClient) newChunk(p *Packet, flag int64, data []byte) *Packet {
+	header := p.Header
+	header.Extension = header.Extension&^(EXT_CHUNK_FLAG_MASK|EXT_COMPRESS_MASK) | flag
+	header.BodyLen = int32(len(data))
+	return &Packet{Header: header, Data: data}
+}
+
+//分片写入队列,队列满了则等待写出
+func (self *TClient) writeChunk(ctx context.Context, chunk *Packet, errc chan error) error {
+	check := time.NewTicker(100 * time.Millisecond)
+	defer check.Stop()
+
+	atomic.AddInt32(&self.pending, 1)
+	for {
+		select {
+		case err := <-errc:
+			atomic.AddInt32(&self.pending, -1)
+			return err
+		default:
+		}
+
+		select {
+		case self.schan <- chunk:
+			return nil
+		case err := <-errc:
+			atomic.AddInt32(&self.pending, -1)
+			return err
+		case <-ctx.Done():
+			atomic.AddInt32(&self.pending, -1)
+			return ctx.Err()
+		case <-self.ctx.Done():
+			atomic.AddInt32(&self.pending, -1)
+			return newTurboError(ErrKindBroken, ERR_CONNECTION_BROKEN,
+				self.remoteAddr, chunk.Header.Opaque, chunk.Header.CmdType)
+		case <-check.C:
+			if self.IsClosed() {
+				atomic.AddInt32(&self.pending, -1)
+				return newTurboError(ErrKindBroken, ERR_CONNECTION_BROKEN,
+					self.remoteAddr, chunk.Header.Opaque, chunk.Header.CmdType)
+			}
+		}
+	}
+}
+
+//通知对端中断,尽力而为
+func (self *TClient) abortStream(p *Packet, seq uint32) {
+	data := make([]byte, STREAM_SEQ_BYTES)
+	binary.BigEndian.PutUint32(data, seq)
+	chunk := self.newChunk(p, EXT_CHUNK|EXT_CHUNK_ABORT, data)
+	chunk.OnComplete = func(err error) {}
+	atomic.AddInt32(&self.pending, 1)
+	select {
+	case self.wchan <- chunk:
+	default:
+		atomic.AddInt32(&self.pending, -1)
+	}
+}
+
+//收到分片
+func (self *TClient) onChunk(msg Packet) {
+	//合并时已经拷贝,交给StreamHandler的不能归还
+	release := true
+	defer func() {
+		if release {
+			msg.Release()
+		}
+	}()
+
+	header := msg.Header
+	if len(msg.Data) < STREAM_SEQ_BYTES {
+		log.Errorf("TClient|onChunk|%s|FAIL|INVALID CHUNK|opaque:%d|bodyLen:%d",
+			self.remoteAddr, header.Opaque, len(msg.Data))
+		self.streamError(header, newTurboError(ErrKindCodec, ERR_INVALID_FRAME,
+			self.remoteAddr, header.Opaque, header.CmdType))
+		return
+	}
+	seq := binary.BigEndian.Uint32(msg.Data)
+	data := msg.Data[STREAM_SEQ_BYTES:]
+	flag := header.Extension & EXT_CHUNK_FLAG_MASK
+
+	a := self.streams.get(header.Opaque)
+	if flag&EXT_CHUNK_ABORT != 0 {
+		if nil != a {
+			self.streams.remove(header.Opaque)
+			a.abort(ERR_STREAM_ABORTED)
+		}
+		return
+	}
+
+	if nil == a {
+		if seq != 0 {
+			//开始的分片已经丢弃
+			log.Errorf("TClient|onChunk|%s|FAIL|UNKNOWN STREAM|opaque:%d|seq:%d",
+				self.remoteAddr, header.Opaque, seq)
+			return
+		}
+		//只有读协程创建大包,先判断再放入不会超过上限
+		if max := self.config.MaxStreams; max > 0 && self.streams.size() >= max {
+			err := newTurboError(ErrKindBackpressure, ERR_TOO_MANY_STREAMS,
+				self.remoteAddr, header.Opaque, header.CmdType)
+			log.Errorf("TClient|onChunk|%s|FAIL|%v|max:%d", self.remoteAddr, err, max)
+			self.streamError(header, err)
+			return
+		}
+		na, err := self.newAssembler(header)
+		if nil != err {
+			self.streamError(header, err)
+			return
+		}
+		a = na
+		self.streams.put(header.Opaque, a)
+		self.startStreamSweep()
+	}
+	atomic.StoreInt64(&a.lastChunk, time.Now().UnixNano())
+
+	var err error
+	release = nil == a.reader
+	if seq != a.seq {
+		err = newTurboError(ErrKindCodec, fmt.Errorf("%w|seq:%d/%d", ERR_STREAM_SEQ, seq, a.seq),
+			self.remoteAddr, header.Opaque, header.CmdType)
+	} else if aerr := a.append(data); nil != aerr {
+		err = newTurboError(ErrorKindOf(aerr), aerr,
+			self.remoteAddr, header.Opaque, header.CmdType)
+	}
+	if nil != err {
+		log.Errorf("TClient|onChunk|%s|FAIL|%v", self.remoteAddr, err)
+		self.streams.remove(header.Opaque)
+		a.abort(err)
+		self.streamError(header, err)
+		return
+	}
+	a.seq++
+
+	if flag&EXT_CHUNK_END != 0 {
+		self.streams.remove(header.Opaque)
+		if nil != a.reader {
+			a.reader.finish(nil)
+			return
+		}
+		//合并完成,按照普通的包处理
+		header.Extension &^= EXT_CHUNK_FLAG_MASK
+		header.BodyLen = int32(len(a.buff))
+		self.dispatch(&Packet{Header: header, Data: a.buff})
+	}
+}
+
+//大包接收失败
+func (self *TClient) streamError(header PacketHeader, err error) {
+	header.Extension &^= EXT_CHUNK_FLAG_MASK
+	self.replyError(header, err)
+	ctx := &TContext{
+		Message: &Packet{Header: header},
+		Client:  self,
+		Err:     err,
+	}
+	if err := self.dis(ctx); nil != err {
+		log.Errorf("TClient|onChunk|dis|FAIL|%s|%v", self.remoteAddr, err)
+	}
+}
+
+//开启未完成大包的空闲检查,第一次收到大包时开启,连接关闭时停止
+func (self *TClient) startStreamSweep() {
+	timeout := self.config.StreamIdleTimeout
+	if timeout <= 0 || atomic.LoadUint32(&self.streamTid) > 0 {
+		return
+	}
+	tid := self.config.TW.RepeatedTimer(timeout/2, func(tid uint32, t time.Time) {
+		self.sweepStreams(timeout)
+	}, nil)
+	if !atomic.CompareAndSwapUint32(&self.streamTid, 0, tid) {
+		self.config.TW.CancelTimer(tid)
+	}
+}
+
+//停止空闲检查
+func (self *TClient) stopStreamSweep() {
+	if tid := atomic.SwapUint32(&self.streamTid, 0); tid > 0 {
+		self.config.TW.CancelTimer(tid)
+	}
+}
+
+//中断超过timeout没有收到分片的大包,对端后续的分片按照未知的大包丢弃
+func (self *TClient) sweepStreams(timeout time.Duration) {
+	if self.IsClosed() {
+		self.stopStreamSweep()
+		return
+	}
+	for _, a := range self.streams.expire(time.Now().Add(-timeout)) {
+		err := newTurboError(ErrKindTimeout, ERR_STREAM_IDLE,
+			self.remoteAddr, a.header.Opaque, a.header.CmdType)
+		log.Warnf("TClient|sweepStreams|%s|%v", self.remoteAddr, err)
+		//合并的数据由读协程持有,这里只结束StreamHandler的读取
+		if nil != a.reader {
+			a.reader.finish(err)
+		}
+		self.streamError(a.header, err)
+	}
+}
+
+//创建大包的接收,配置了StreamHandler则以io.Reader的方式处理
+//在读协程中调用不能阻塞,分发的pool没有空闲协程时以背压的错误拒绝该大包
+func (self *TClient) newAssembler(header PacketHeader) (*streamAssembler, error) {
+	header.Extension &^= EXT_CHUNK_FLAG_MASK
+	header.BodyLen = 0
+	a := &streamAssembler{maxBytes: self.config.MaxStreamBytes, header: header}
+	handler := self.config.StreamHandler
+	if nil == handler {
+		return a, nil
+	}
+
+	reader := newStreamReader(self.config.MaxStreamBytes)
+	a.reader = reader
+	_, err := self.config.dispool.TryQueue(self.ctx, func(cctx context.Context) (interface{}, error) {
+		defer reader.stop()
+		ctx := &TContext{
+			Message: &Packet{Header: header},
+			Client:  self,
+		}
+		err := handler(ctx, reader)
+		if nil != err {
+			log.Errorf("TClient|StreamHandler|FAIL|%s|opaque:%d|%v", self.remoteAddr, header.Opaque, err)
+		}
+		return nil, err
+	})
+	if nil != err {
+		log.Errorf("TClient|StreamHandler|Queue|FAIL|%s|opaque:%d|%v", self.remoteAddr, header.Opaque, err)
+		reader.stop()
+		return nil, newTurboError(ErrKindBackpressure, ERR_OVER_FLOW,
+			self.remoteAddr, header.Opaque, header.CmdType)
+	}
+	return a, nil
+}
+
+//正在接收的大包
+type streams struct {
+	lock       sync.Mutex
+	assemblers map[uint32]*streamAssembler
+}
+
+func newStreams() *streams {
+	return &streams{assemblers: make(map[uint32]*streamAssembler)}
+}
+
+func (self *streams) get(opaque uint32) *streamAssembler {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.assemblers[opaque]
+}
+
+func (self *streams) put(opaque uint32, a *streamAssembler) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.assemblers[opaque] = a
+}
+
+func (self *streams) remove(opaque uint32) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.assemblers, opaque)
+}
+
+func (self *streams) size() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return len(self.assemblers)
+}
+
+//移除并返回deadline之前收到最后一个分片的大包
+func (self *streams) expire(deadline time.Time) []*streamAssembler {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	var expired []*streamAssembler
+	for opaque, a := range self.assemblers {
+		if atomic.LoadInt64(&a.lastChunk) < deadline.UnixNano() {
+			delete(self.assemblers, opaque)
+			expired = append(expired, a)
+		}
+	}
+	return expired
+}
+
+//中断所有正在接收的大包
+func (self *streams) abortAll(err error) {
+	self.lock.Lock()
+	assemblers := self.assemblers
+	self.assemblers = make(map[uint32]*streamAssembler)
+	self.lock.Unlock()
+	for _, a := range assemblers {
+		a.abort(err)
+	}
+}
+
+//大包的合并
+type streamAssembler struct {
+	seq       uint32       //下一个分片的序号
+	lastChunk int64        //最后一个分片的接收时间
+	header    PacketHeader //去掉分片标记的包头
+	maxBytes  int
+	buff      []byte
+	reader    *streamReader //不为空则交给StreamHandler读取
+}
+
+//追加分片,超过最大字节数返回ERR_TOO_LARGE_PACKET
+//StreamHandler处理不过来返回ERR_STREAM_OVERFLOW,不阻塞读协程
+func (self *streamAssembler) append(data []byte) error {
+	if nil != self.reader {
+		if !self.reader.write(data) {
+			return ERR_STREAM_OVERFLOW
+		}
+		return nil
+	}
+	if self.maxBytes > 0 && len(self.buff)+len(data) > self.maxBytes {
+		return ERR_TOO_LARGE_PACKET
+	}
+	self.buff = append(self.buff, data...)
+	return nil
+}
+
+func (self *streamAssembler) abort(err error) {
+	self.buff = nil
+	if nil != self.reader {
+		self.reader.finish(err)
+	}
+}
+
+//交给StreamHandler的io.Reader
+//写入不阻塞,没有读取的分片超过maxBytes则写入失败
+type streamReader struct {
+	lock     sync.Mutex
+	chunks   [][]byte
+	buffered int //已经写入还没有读取的字节数
+	maxBytes int
+	notify   chan struct{} //有新的分片
+	end      chan struct{} //写入结束
+	done     chan struct{} //StreamHandler已经返回
+	err      error         //写入结束的原因,正常结束为nil
+	buff     []byte
+	endOnce  sync.Once
+	doneOnce sync.Once
+}
+
+func newStreamReader(maxBytes int) *streamReader {
+	return &streamReader{
+		maxBytes: maxBytes,
+		notify:   make(chan struct{}, 1),
+		end:      make(chan struct{}),
+		done:     make(chan struct{})}
+}
+
+func (self *streamReader) Read(p []byte) (int, error) {
+	for len(self.buff) <= 0 {
+		if data, ok := self.next(); ok {
+			self.buff = data
+			continue
+		}
+		select {
+		case <-self.notify:
+		case <-self.end:
+			//先读完已经写入的分片
+			if data, ok := self.next(); ok {
+				self.buff = data
+				continue
+			}
+			if nil != self.err {
+				return 0, self.err
+			}
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, self.buff)
+	self.buff = self.buff[n:]
+	return n, nil
+}
+
+//取出一个写入的分片
+func (self *streamReader) next() ([]byte, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(self.chunks) <= 0 {
+		return nil, false
+	}
+	data := self.chunks[0]
+	self.chunks[0] = nil
+	self.chunks = self.chunks[1:]
+	self.buffered -= len(data)
+	return data, true
+}
+
+//写入分片,读取方不再读取时直接丢弃
+//没有读取的分片超过maxBytes返回false
+func (self *streamReader) write(data []byte) bool {
+	if len(data) <= 0 {
+		return true
+	}
+	select {
+	case <-self.done:
+		return true
+	default:
+	}
+
+	self.lock.Lock()
+	if self.maxBytes > 0 && self.buffered+len(data) > self.maxBytes {
+		self.lock.Unlock()
+		return false
+	}
+	self.chunks = append(self.chunks, data)
+	self.buffered += len(data)
+	self.lock.Unlock()
+
+	select {
+	case self.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+//写入结束
+func (self *streamReader) finish(err error) {
+	self.endOnce.Do(func() {
+		self.err = err
+		close(self.end)
+	})
+}
+
+//读取方退出
+func (self *streamReader) stop() {
+	self.doneOnce.Do(func() {
+		close(self.done)
+	})
+}
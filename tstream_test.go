@@ -0,0 +1,303 @@
+package turbo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+//回复body的长度和sha1
+func digest(data []byte) string {
+	return fmt.Sprintf("%d|%x", len(data), sha1.Sum(data))
+}
+
+func TestWriteStream(t *testing.T) {
+	handler := func(ctx *TContext) error {
+		p := ctx.Message
+		if nil != ctx.Err {
+			if ErrorKindOf(ctx.Err) == ErrKindTooLarge {
+				resp := NewRespPacket(p.Header.Opaque, p.Header.CmdType, nil)
+				resp.PayLoad = []byte(ErrKindTooLarge.String())
+				ctx.Client.Write(*resp)
+			}
+			return nil
+		}
+		//普通的请求echo,大包回复摘要
+		resp := NewRespPacket(p.Header.Opaque, p.Header.CmdType, nil)
+		if len(p.Data) > MAX_PACKET_BYTES {
+			resp.PayLoad = []byte(digest(p.Data))
+		} else {
+			resp.PayLoad = p.Data
+		}
+		ctx.Client.Write(*resp)
+		return nil
+	}
+	_, client := newTestPair(t, "localhost:28896", handler)
+
+	payload := make([]byte, 5*1024*1024+17)
+	rand.Read(payload)
+
+	//大包传输过程中普通的请求不会被阻塞
+	done := make(chan time.Duration, 1)
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		start := time.Now()
+		p := NewPacket(1, nil)
+		p.PayLoad = []byte("echo")
+		resp, err := client.WriteAndGetContext(context.Background(), p)
+		if nil != err || string(resp.([]byte)) != "echo" {
+			t.Errorf("unexpected response %v|%v", resp, err)
+		}
+		done <- time.Since(start)
+	}()
+
+	p := NewPacket(2, nil)
+	resp, err := client.WriteStreamAndGet(context.Background(), p, bytes.NewReader(payload))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if string(resp.([]byte)) != digest(payload) {
+		t.Fatalf("unexpected digest %s", resp)
+	}
+	select {
+	case cost := <-done:
+		t.Logf("request during stream cost %s", cost)
+	case <-time.After(5 * time.Second):
+		t.Fatal("request starved by stream")
+	}
+
+	//超过MaxStreamBytes的大包,对端回复TOO_LARGE的错误响应
+	_, client = newTestPair(t, "localhost:28916", handler, func(config *TConfig) {
+		config.MaxStreamBytes = 1024 * 1024
+	})
+	resp, err = client.WriteStreamAndGet(context.Background(), NewPacket(2, nil), bytes.NewReader(payload))
+	var remote *RemoteError
+	if !errors.As(err, &remote) || remote.Code != ERR_CODE_TOO_LARGE {
+		t.Fatalf("expect too large %v|%v", resp, err)
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	digests := make(chan string, 1)
+	_, client := newTestPair(t, "localhost:28897", func(ctx *TContext) error {
+		return nil
+	}, func(config *TConfig) {
+		config.StreamHandler = func(ctx *TContext, body io.Reader) error {
+			data, err := ioutil.ReadAll(body)
+			if nil != err {
+				digests <- err.Error()
+				return err
+			}
+			digests <- digest(data)
+			return nil
+		}
+	})
+
+	payload := make([]byte, 3*1024*1024)
+	rand.Read(payload)
+	err := client.WriteStream(context.Background(), NewPacket(2, nil), bytes.NewReader(payload))
+	if nil != err {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-digests:
+		if d != digest(payload) {
+			t.Fatalf("unexpected digest %s", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream handler not called")
+	}
+
+	//发送方中断,读取方收到错误
+	r := io.MultiReader(bytes.NewReader(payload), &failReader{})
+	err = client.WriteStream(context.Background(), NewPacket(2, nil), r)
+	if nil == err {
+		t.Fatal("expect read error")
+	}
+	select {
+	case d := <-digests:
+		if d != ERR_STREAM_ABORTED.Error() {
+			t.Fatalf("expect aborted %s", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream handler not called")
+	}
+}
+
+type failReader struct{}
+
+func (self *failReader) Read(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+//连接到丢弃所有数据的tcp server的client,分片直接交给onChunk,错误写入errs
+func newStreamClient(t *testing.T, setup func(config *TConfig)) (*TClient, chan error) {
+	config := NewTConfig(
+		"turbo-client:stream",
+		100, 16*1024,
+		16*1024, 100, 100,
+		10*time.Second,
+		1000)
+	setup(config)
+	errs := make(chan error, 10)
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if remote, err := l.Accept(); nil == err {
+			io.Copy(ioutil.Discard, remote)
+			remote.Close()
+		}
+	}()
+	local, err := net.Dial("tcp4", l.Addr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	client := NewTClient(context.Background(), local, func() ICodec {
+		return LengthBytesCodec{MaxFrameLength: MAX_PACKET_BYTES}
+	}, func(ctx *TContext) error {
+		if nil != ctx.Err {
+			errs <- ctx.Err
+		}
+		return nil
+	}, config)
+	client.Start()
+	t.Cleanup(func() {
+		client.Shutdown()
+	})
+	return client, errs
+}
+
+func chunkPacket(opaque, seq uint32, flag int64, data []byte) Packet {
+	body := make([]byte, STREAM_SEQ_BYTES+len(data))
+	binary.BigEndian.PutUint32(body, seq)
+	copy(body[STREAM_SEQ_BYTES:], data)
+	p := NewRespPacket(opaque, 2, body)
+	p.Header.Extension = flag
+	return *p
+}
+
+func TestMaxStreams(t *testing.T) {
+	client, errs := newStreamClient(t, func(config *TConfig) {
+		config.MaxStreams = 2
+	})
+	for opaque := uint32(1); opaque <= 3; opaque++ {
+		client.onChunk(chunkPacket(opaque, 0, EXT_CHUNK, []byte("chunk")))
+	}
+	select {
+	case err := <-errs:
+		var terr *TurboError
+		if !errors.Is(err, ERR_TOO_MANY_STREAMS) || !errors.As(err, &terr) || terr.Opaque != 3 {
+			t.Fatalf("expect too many streams %v", err)
+		}
+	default:
+		t.Fatal("third stream should be rejected")
+	}
+	if client.streams.size() != 2 {
+		t.Fatalf("unexpected streams %d", client.streams.size())
+	}
+}
+
+//分发的pool没有空闲协程时拒绝新的大包,不阻塞读协程
+func TestStreamHandlerBusy(t *testing.T) {
+	block := make(chan bool)
+	defer close(block)
+	client, errs := newStreamClient(t, func(config *TConfig) {
+		config.dispool = NewLimitPool(context.Background(), 1)
+		config.StreamHandler = func(ctx *TContext, body io.Reader) error {
+			<-block
+			return nil
+		}
+	})
+	client.onChunk(chunkPacket(1, 0, EXT_CHUNK, []byte("chunk")))
+	client.onChunk(chunkPacket(2, 0, EXT_CHUNK, []byte("chunk")))
+	select {
+	case err := <-errs:
+		var terr *TurboError
+		if !errors.Is(err, ERR_OVER_FLOW) || ErrorKindOf(err) != ErrKindBackpressure ||
+			!errors.As(err, &terr) || terr.Opaque != 2 {
+			t.Fatalf("expect backpressure %v", err)
+		}
+	default:
+		t.Fatal("second stream should be rejected")
+	}
+	if client.streams.size() != 1 {
+		t.Fatalf("unexpected streams %d", client.streams.size())
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	client, errs := newStreamClient(t, func(config *TConfig) {
+		config.StreamIdleTimeout = 200 * time.Millisecond
+	})
+	client.onChunk(chunkPacket(1, 0, EXT_CHUNK, []byte("chunk")))
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ERR_STREAM_IDLE) || ErrorKindOf(err) != ErrKindTimeout {
+			t.Fatalf("expect stream idle %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle stream not expired")
+	}
+	if client.streams.size() != 0 {
+		t.Fatalf("idle stream not removed %d", client.streams.size())
+	}
+}
+
+//StreamHandler处理不过来的时候中断大包,不阻塞读协程
+func TestStreamHandlerOverflow(t *testing.T) {
+	release := make(chan struct{})
+	result := make(chan error, 1)
+	client, errs := newStreamClient(t, func(config *TConfig) {
+		config.MaxStreamBytes = 1024
+		config.StreamHandler = func(ctx *TContext, body io.Reader) error {
+			<-release
+			_, err := ioutil.ReadAll(body)
+			result <- err
+			return err
+		}
+	})
+
+	chunk := make([]byte, 512)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for seq := uint32(0); seq < 3; seq++ {
+			client.onChunk(chunkPacket(1, seq, EXT_CHUNK, chunk))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow stream handler blocks onChunk")
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ERR_STREAM_OVERFLOW) || ErrorKindOf(err) != ErrKindBackpressure {
+			t.Fatalf("expect stream overflow %v", err)
+		}
+	default:
+		t.Fatal("overflow not reported")
+	}
+
+	close(release)
+	select {
+	case err := <-result:
+		if !errors.Is(err, ERR_STREAM_OVERFLOW) {
+			t.Fatalf("handler should read overflow %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream handler not finished")
+	}
+}
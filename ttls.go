@@ -0,0 +1,118 @@
+package turbo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+)
+
+var ERR_NO_TLS_CONFIG = errors.New("NO TLS CONFIG")
+var ERR_INVALID_CA = errors.New("INVALID CA CERTIFICATE")
+
+//服务端tls握手的超时,对端建立连接之后不发送握手不能一直占用连接数
+const TLS_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+//加载ca证书
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	raw, err := ioutil.ReadFile(caFile)
+	if nil != err {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, ERR_INVALID_CA
+	}
+	return pool, nil
+}
+
+//服务端的tls配置
+//clientCAFile不为空则开启双向认证，校验客户端证书
+func NewServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if nil != err {
+		log.Errorf("NewServerTLSConfig|LoadX509KeyPair|FAIL|%v|%s|%s", err, certFile, keyFile)
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12}
+
+	if len(clientCAFile) > 0 {
+		pool, err := loadCertPool(clientCAFile)
+		if nil != err {
+			log.Errorf("NewServerTLSConfig|LoadClientCA|FAIL|%v|%s", err, clientCAFile)
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+//客户端的tls配置
+//caFile为空则使用系统的根证书，certFile/keyFile不为空则携带客户端证书用于双向认证
+func NewClientTLSConfig(serverName, caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12}
+
+	if len(caFile) > 0 {
+		pool, err := loadCertPool(caFile)
+		if nil != err {
+			log.Errorf("NewClientTLSConfig|LoadCA|FAIL|%v|%s", err, caFile)
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if len(certFile) > 0 && len(keyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if nil != err {
+			log.Errorf("NewClientTLSConfig|LoadX509KeyPair|FAIL|%v|%s|%s", err, certFile, keyFile)
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+//建立tls连接并完成握手
+//返回的连接直接用于NewTClient
+//这样创建的TClient断开之后不能重连,需要重连的使用Dial并设置DialOptions.TLSConfig
+func DialTLS(hostport string, tlsConfig *tls.Config, timeout time.Duration) (*tls.Conn, error) {
+	if nil == tlsConfig {
+		return nil, ERR_NO_TLS_CONFIG
+	}
+	conn, err := dialConn(context.Background(), hostport, &DialOptions{
+		TLSConfig: tlsConfig,
+		Timeout:   timeout})
+	if nil != err {
+		return nil, err
+	}
+	return conn.(*tls.Conn), nil
+}
+
+//服务端完成tls握手之后再启动TClient,超时或者失败关闭连接
+func (self *TServer) handshake(conn *tls.Conn, ip string) {
+	conn.SetDeadline(time.Now().Add(TLS_HANDSHAKE_TIMEOUT))
+	if err := conn.Handshake(); nil != err {
+		log.Errorf("TServer|Handshake|FAIL|%s|%v", conn.RemoteAddr(), err)
+		conn.Close()
+		self.release(ip)
+		return
+	}
+	//握手完成，取消超时
+	conn.SetDeadline(time.Time{})
+	//握手过程中已经Shutdown
+	if atomic.LoadInt32(&self.isShutdown) == 1 {
+		conn.Close()
+		self.release(ip)
+		return
+	}
+	self.startClient(conn, ip)
+}
@@ -0,0 +1,145 @@
+package turbo
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//生成证书并写入到dir下,返回cert和key的文件路径
+func genCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+	isCA bool) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if nil != err {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if nil == parent {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if nil != err {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	rawKey, _ := x509.MarshalECPrivateKey(key)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0600)
+	return cert, key, certFile, keyFile
+}
+
+//双向认证的tls echo
+func TestTLSServer(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, caFile, _ := genCert(t, dir, "ca", nil, nil, true)
+	_, _, serverCert, serverKey := genCert(t, dir, "server", ca, caKey, false)
+	_, _, clientCert, clientKey := genCert(t, dir, "client", ca, caKey, false)
+
+	serverTLS, err := NewServerTLSConfig(serverCert, serverKey, caFile)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	serConfig := NewTConfig(
+		"turbo-server:localhost:28890",
+		100, 16*1024,
+		16*1024, 100, 100,
+		10*time.Second,
+		1000)
+	server := NewTServer("localhost:28890", serConfig, func(ctx *TContext) error {
+		p := ctx.Message
+		resp := NewRespPacket(p.Header.Opaque, p.Header.CmdType, nil)
+		resp.PayLoad = p.Data
+		ctx.Client.Write(*resp)
+		return nil
+	})
+	if err := server.ListenAndServerTLS(serverTLS); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	//没有客户端证书握手失败
+	noCertTLS, _ := NewClientTLSConfig("localhost", caFile, "", "")
+	if conn, err := DialTLS("localhost:28890", noCertTLS, time.Second); nil == err {
+		//tls1.3的客户端证书校验失败在握手之后的第一次读才能感知
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); nil == err {
+			t.Fatal("handshake without client cert should fail")
+		}
+		conn.Close()
+	}
+
+	clientTLS, err := NewClientTLSConfig("localhost", caFile, clientCert, clientKey)
+	if nil != err {
+		t.Fatal(err)
+	}
+	conn, err := DialTLS("localhost:28890", clientTLS, time.Second)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	config := NewTConfig(
+		"turbo-client:localhost:28890",
+		100, 16*1024,
+		16*1024, 100, 100,
+		10*time.Second,
+		1000)
+	client := NewTClient(context.Background(), conn, func() ICodec {
+		return LengthBytesCodec{MaxFrameLength: MAX_PACKET_BYTES}
+	}, func(ctx *TContext) error {
+		ctx.Client.Attach(ctx.Message.Header.Opaque, ctx.Message.Data)
+		return nil
+	}, config)
+	client.Start()
+	defer client.Shutdown()
+
+	if _, ok := client.TLSConnectionState(); !ok {
+		t.Fatal("client should be tls")
+	}
+
+	p := NewPacket(1, nil)
+	p.PayLoad = []byte("echo")
+	resp, err := client.WriteAndGet(*p, time.Second)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if string(resp.([]byte)) != "echo" {
+		t.Fatalf("unexpected response %s", resp)
+	}
+
+	//服务端记录了tls连接的流量
+	time.Sleep(100 * time.Millisecond)
+	if len(server.ListClients()) != 1 {
+		t.Fatalf("server clients %v", server.ListClients())
+	}
+
+	//DialTLS创建的连接不能按照普通的tcp重连
+	if err := client.connect(context.Background()); !errors.Is(err, ERR_TLS_RECONNECT) {
+		t.Fatalf("expect tls reconnect refused %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const UNIX_ADDR_PREFIX = "unix:"
+
+//合成地址的序号,unix socket和内存中的连接使用
+var connSeq uint32 = 0
+
+//unix socket的连接没有host:port
+//已命名的一端使用 unix:path
+//未命名的一端使用 unix:path#seq 保证在ClientManager和FlowStat中唯一
+func unixConnAddrs(conn net.Conn) (string, string) {
+	local := conn.LocalAddr().String()
+	remote := ""
+	if nil != conn.RemoteAddr() {
+		remote = conn.RemoteAddr().String()
+	}
+
+	sockPath := local
+	if len(sockPath) <= 0 || sockPath == "@" {
+		sockPath = remote
+	}
+
+	synthetic := func(name string) string {
+		if len(name) <= 0 || name == "@" {
+			return fmt.Sprintf("%s%s#%d", UNIX_ADDR_PREFIX, sockPath, atomic.AddUint32(&connSeq, 1))
+		}
+		return UNIX_ADDR_PREFIX + name
+	}
+	return synthetic(local), synthetic(remote)
+}
+
+//从合成地址中解析socket路径
+func unixSockPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, UNIX_ADDR_PREFIX) {
+		return "", false
+	}
+	sockPath := strings.TrimPrefix(addr, UNIX_ADDR_PREFIX)
+	if idx := strings.LastIndex(sockPath, "#"); idx > 0 {
+		sockPath = sockPath[:idx]
+	}
+	return sockPath, true
+}
+
+var ERR_SOCKET_IN_USE = errors.New("UNIX SOCKET IN USE")
+
+//删除残留的socket文件,不是socket的文件不删除
+//先尝试连接,只有连接被拒绝(没有进程监听)才删除,避免删掉其他进程正在使用的socket
+func removeStaleSocket(sockPath string) error {
+	fi, err := os.Stat(sockPath)
+	if nil != err {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", sockPath)
+	}
+	conn, err := net.DialTimeout("unix", sockPath, time.Second)
+	if nil == err {
+		conn.Close()
+		return fmt.Errorf("%w|%s", ERR_SOCKET_IN_USE, sockPath)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+	return os.Remove(sockPath)
+}
+
+//建立unix socket连接
+//返回的连接直接用于NewTClient
+func DialUnix(sockPath string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("unix", sockPath, timeout)
+	if nil != err {
+		log.Errorf("DialUnix|FAIL|%v|%s", err, sockPath)
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,110 @@
+package turbo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixServer(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "turbo.sock")
+
+	server := NewTServer(sockPath, NewTConfig(
+		"turbo-server:"+sockPath,
+		100, 16*1024,
+		16*1024, 100, 100,
+		10*time.Second,
+		1000), func(ctx *TContext) error {
+		p := ctx.Message
+		resp := NewRespPacket(p.Header.Opaque, p.Header.CmdType, nil)
+		resp.PayLoad = p.Data
+		ctx.Client.Write(*resp)
+		return nil
+	})
+	if err := server.ListenAndServerUnix(sockPath); nil != err {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+
+	clientManager := NewClientManager(NewReconnectManager(false, -1, -1,
+		func(ga *GroupAuth, remoteClient *TClient) (bool, error) {
+			return true, nil
+		}))
+	config := NewTConfig(
+		"turbo-client:"+sockPath,
+		100, 16*1024,
+		16*1024, 100, 100,
+		10*time.Second,
+		1000)
+
+	for i := 0; i < 2; i++ {
+		conn, err := DialUnix(sockPath, time.Second)
+		if nil != err {
+			t.Fatal(err)
+		}
+		client := NewTClient(context.Background(), conn, func() ICodec {
+			return LengthBytesCodec{MaxFrameLength: MAX_PACKET_BYTES}
+		}, func(ctx *TContext) error {
+			ctx.Client.Attach(ctx.Message.Header.Opaque, ctx.Message.Data)
+			return nil
+		}, config)
+		client.Start()
+		defer client.Shutdown()
+		if client.RemoteAddr() != UNIX_ADDR_PREFIX+sockPath {
+			t.Fatalf("unexpected remote addr %s", client.RemoteAddr())
+		}
+		clientManager.Auth(&GroupAuth{GroupId: "a", SecretKey: "123"}, client)
+
+		p := NewPacket(1, nil)
+		p.PayLoad = []byte("echo")
+		resp, err := client.WriteAndGet(*p, time.Second)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if string(resp.([]byte)) != "echo" {
+			t.Fatalf("unexpected response %s", resp)
+		}
+	}
+
+	if nil == clientManager.FindTClient(UNIX_ADDR_PREFIX+sockPath) {
+		t.Fatal("unix client not registered")
+	}
+
+	//服务端每个连接的合成地址不相同
+	clients := server.ListClients()
+	if len(clients) != 2 || clients[0] == clients[1] {
+		t.Fatalf("server clients %v", clients)
+	}
+	if path, ok := unixSockPath(clients[0]); !ok || path != sockPath {
+		t.Fatalf("synthetic addr %s", clients[0])
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "stale.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: sockPath, Net: "unix"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	l.SetUnlinkOnClose(false)
+
+	//还在监听的socket不能删除
+	if err := removeStaleSocket(sockPath); !errors.Is(err, ERR_SOCKET_IN_USE) {
+		t.Fatalf("live socket: %v", err)
+	}
+	if _, err := os.Stat(sockPath); nil != err {
+		t.Fatalf("live socket removed: %v", err)
+	}
+
+	l.Close()
+	if err := removeStaleSocket(sockPath); nil != err {
+		t.Fatalf("stale socket: %v", err)
+	}
+	if _, err := os.Stat(sockPath); !os.IsNotExist(err) {
+		t.Fatalf("stale socket not removed: %v", err)
+	}
+}